@@ -0,0 +1,391 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wolfmagnate/zerorouter/chapter7"
+)
+
+type benchRoute struct {
+	method string
+	path   string
+}
+
+var restResources = []string{
+	"users", "teams", "projects", "issues", "comments", "labels", "milestones", "releases",
+	"deployments", "webhooks", "invoices", "payments", "orders", "products", "carts", "reviews",
+}
+
+// リソースごとに一覧・作成・取得・置換・更新・削除を持つREST API
+func restAPIRoutes() []benchRoute {
+	routes := make([]benchRoute, 0, len(restResources)*8)
+	for _, res := range restResources {
+		routes = append(routes,
+			benchRoute{http.MethodGet, "/" + res},
+			benchRoute{http.MethodPost, "/" + res},
+			benchRoute{http.MethodGet, "/" + res + "/:id"},
+			benchRoute{http.MethodPut, "/" + res + "/:id"},
+			benchRoute{http.MethodPatch, "/" + res + "/:id"},
+			benchRoute{http.MethodDelete, "/" + res + "/:id"},
+			benchRoute{http.MethodGet, "/" + res + "/:id/history"},
+			benchRoute{http.MethodDelete, "/" + res + "/:id/history"},
+		)
+	}
+	return routes
+}
+
+func benchHandle(http.ResponseWriter, *http.Request, Params) {}
+
+func benchHandleChapter7(http.ResponseWriter, *http.Request, chapter7.Params) {}
+
+func loadRouter(routes []benchRoute) *Router {
+	router := New()
+	for _, route := range routes {
+		router.Handle(route.method, route.path, benchHandle)
+	}
+	return router
+}
+
+func loadChapter7Router(routes []benchRoute) *chapter7.Router {
+	router := chapter7.New()
+	for _, route := range routes {
+		router.Handle(route.method, route.path, benchHandleChapter7)
+	}
+	return router
+}
+
+type benchResponseWriter struct {
+	header http.Header
+}
+
+func (w *benchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *benchResponseWriter) WriteHeader(int) {}
+
+func newBenchResponseWriter() *benchResponseWriter {
+	return &benchResponseWriter{header: make(http.Header)}
+}
+
+func benchRequests(b *testing.B, router http.Handler, requests []*http.Request) {
+	w := newBenchResponseWriter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, req := range requests {
+			router.ServeHTTP(w, req)
+		}
+	}
+}
+
+func newRequests(b *testing.B, pairs ...string) []*http.Request {
+	requests := make([]*http.Request, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		req, err := http.NewRequest(pairs[i], pairs[i+1], nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+func BenchmarkBuildRESTAPI(b *testing.B) {
+	routes := restAPIRoutes()
+	b.Run("UnifiedTree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loadRouter(routes)
+		}
+	})
+	b.Run("PerMethodTrees", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loadChapter7Router(routes)
+		}
+	})
+}
+
+func BenchmarkServeRESTAPI(b *testing.B) {
+	routes := restAPIRoutes()
+	requests := newRequests(b,
+		http.MethodGet, "/users",
+		http.MethodGet, "/projects/8f14e45fceea",
+		http.MethodDelete, "/reviews/c9f0f895fb98",
+		http.MethodPatch, "/orders/45c48cce2e2d",
+		http.MethodGet, "/webhooks/d3d94468/history",
+	)
+	b.Run("UnifiedTree", func(b *testing.B) {
+		benchRequests(b, loadRouter(routes), requests)
+	})
+	b.Run("PerMethodTrees", func(b *testing.B) {
+		benchRequests(b, loadChapter7Router(routes), requests)
+	})
+}
+
+func BenchmarkMethodNotAllowedRESTAPI(b *testing.B) {
+	routes := restAPIRoutes()
+	requests := newRequests(b,
+		http.MethodPost, "/users/8f14e45fceea",
+		http.MethodPost, "/reviews/c9f0f895fb98/history",
+		http.MethodOptions, "/orders/45c48cce2e2d/history",
+	)
+	b.Run("UnifiedTree", func(b *testing.B) {
+		router := loadRouter(routes)
+		router.HandleMethodNotAllowed = true
+		router.HandleOPTIONS = true
+		benchRequests(b, router, requests)
+	})
+	b.Run("PerMethodTrees", func(b *testing.B) {
+		router := loadChapter7Router(routes)
+		router.HandleMethodNotAllowed = true
+		router.HandleOPTIONS = true
+		benchRequests(b, router, requests)
+	})
+}
+
+func BenchmarkHandleOPTIONS(b *testing.B) {
+	router := loadRouter(restAPIRoutes())
+	router.HandleOPTIONS = true
+	router.HandleHEAD = true
+
+	b.Run("Static", func(b *testing.B) {
+		benchRequests(b, router, newRequests(b, http.MethodOptions, "/users"))
+	})
+	b.Run("Param", func(b *testing.B) {
+		benchRequests(b, router, newRequests(b, http.MethodOptions, "/users/8f14e45fceea"))
+	})
+	b.Run("Server", func(b *testing.B) {
+		benchRequests(b, router, newRequests(b, http.MethodOptions, "*"))
+	})
+}
+
+func BenchmarkHandleMethodNotAllowed(b *testing.B) {
+	router := loadRouter(restAPIRoutes())
+	router.HandleMethodNotAllowed = true
+
+	b.Run("Static", func(b *testing.B) {
+		benchRequests(b, router, newRequests(b, http.MethodDelete, "/users"))
+	})
+	b.Run("Param", func(b *testing.B) {
+		benchRequests(b, router, newRequests(b, http.MethodPost, "/users/8f14e45fceea"))
+	})
+}
+
+// 子ノードを先頭から順に比較していく、indicesを使わない探索
+// 比較対象としてベンチマークでだけ使う
+func (n *node) retrieve_linear(path string, params *paramsCollector) *methodTable {
+	var fallback *node
+	var fallbackPath string
+	var fallbackParams int
+walk:
+	if len(path) == 0 {
+		if n.table != nil {
+			return n.table
+		}
+		goto fallback
+	}
+	for _, child := range n.children {
+		if child.nType == catchAll && path[0] == '/' {
+			fallback = child
+			fallbackPath = path
+			fallbackParams = params.len()
+		}
+	}
+	for _, child := range n.children {
+		switch child.nType {
+		case static:
+			if len(child.path) <= len(path) && child.path == path[:len(child.path)] {
+				n = child
+				path = path[len(child.path):]
+				goto walk
+			}
+		case param:
+			if path[0] == '/' {
+				goto fallback
+			}
+			end := 1
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+			params.add(path[:end])
+			n = child
+			path = path[end:]
+			goto walk
+		}
+	}
+fallback:
+	if fallback == nil {
+		return nil
+	}
+	params.truncate(fallbackParams)
+	params.add(fallbackPath)
+	return fallback.table
+}
+
+// ルートのパターンから、そのルートにマッチするリクエストパスを作る
+func requestPath(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case ':':
+			for i+1 < len(pattern) && pattern[i+1] != '/' {
+				i++
+			}
+			b.WriteString("value")
+		case '*':
+			b.WriteString("static/js/app.js")
+			i = len(pattern)
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+func benchLookup(b *testing.B, routes []benchRoute) {
+	router := loadRouter(routes)
+	paths := make([]string, 0, len(routes))
+	for _, route := range routes {
+		paths = append(paths, requestPath(route.path))
+	}
+
+	buf := Params{values: make([]string, 0, 10)}
+	collector := &paramsCollector{ps: &buf}
+	for _, path := range paths {
+		if table := router.tree.retrieve_linear(path, collector); table == nil {
+			b.Fatalf("no route for %s", path)
+		}
+		buf.values = buf.values[:0]
+	}
+
+	b.Run("Indices", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				router.tree._retrieve(path, "", false, collector)
+				buf.values = buf.values[:0]
+			}
+		}
+	})
+	frozen := freezeTree(router.tree)
+	b.Run("Frozen", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				frozen._retrieve(path, "", false, collector)
+				buf.values = buf.values[:0]
+			}
+		}
+	})
+	b.Run("Loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				router.tree.retrieve_linear(path, collector)
+				buf.values = buf.values[:0]
+			}
+		}
+	})
+}
+
+func BenchmarkLookupStatic(b *testing.B) {
+	benchLookup(b, staticRoutes)
+}
+
+func BenchmarkLookupGitHubAPI(b *testing.B) {
+	benchLookup(b, githubAPIRoutes)
+}
+
+func BenchmarkLookupParseAPI(b *testing.B) {
+	benchLookup(b, parseAPIRoutes)
+}
+
+// StaticFastPathを有効にした場合と無効にした場合のServeHTTPの比較
+// 静的なルートへのリクエストは木をたどらずに済むが、パラメータを含むルートへの
+// リクエストではmapを1回余分に引くことになるので、静的なリクエストの割合で損得が変わる
+// GitHub APIのルートでは、静的なリクエストが4割から5割を超えたあたりで有効にした方が速くなる
+func BenchmarkStaticFastPath(b *testing.B) {
+	var static, dynamic []benchRoute
+	for _, route := range githubAPIRoutes {
+		if _, i, _ := findWildcard(route.path); i < 0 {
+			static = append(static, route)
+		} else {
+			dynamic = append(dynamic, route)
+		}
+	}
+
+	const total = 100
+	for _, percent := range []int{0, 25, 40, 50, 75, 100} {
+		// 割合どおりに静的なリクエストとパラメータを含むリクエストを交互に並べる
+		pairs := make([]string, 0, total*2)
+		for i := 0; i < total; i++ {
+			route := dynamic[i%len(dynamic)]
+			if (i+1)*percent/100 > i*percent/100 {
+				route = static[i%len(static)]
+			}
+			pairs = append(pairs, route.method, requestPath(route.path))
+		}
+		requests := newRequests(b, pairs...)
+
+		for _, enabled := range []bool{false, true} {
+			router := loadRouter(githubAPIRoutes)
+			router.StaticFastPath = enabled
+			name := "Static" + strconv.Itoa(percent) + "/Off"
+			if enabled {
+				name = "Static" + strconv.Itoa(percent) + "/On"
+			}
+			b.Run(name, func(b *testing.B) {
+				benchRequests(b, router, requests)
+			})
+		}
+	}
+}
+
+// キャッシュに収まる場合は木をたどらずに済むが、
+// 収まらない場合や静的なルートばかりの場合はキャッシュの管理の分だけ遅くなる
+func BenchmarkLookupCache(b *testing.B) {
+	sets := []struct {
+		name   string
+		routes []benchRoute
+	}{
+		{"GitHubAPI", githubAPIRoutes},
+		{"Static", staticRoutes},
+	}
+
+	for _, set := range sets {
+		pairs := make([]string, 0, len(set.routes)*2)
+		for _, route := range set.routes {
+			pairs = append(pairs, route.method, requestPath(route.path))
+		}
+		requests := newRequests(b, pairs...)
+
+		cases := []struct {
+			name string
+			size int
+		}{
+			{"NoCache", 0},
+			{"Fits", len(requests) * 2},
+			{"Thrash", len(requests) / 4},
+		}
+		for _, c := range cases {
+			router := loadRouter(set.routes)
+			router.UseLookupCache(c.size)
+			b.Run(set.name+"/"+c.name, func(b *testing.B) {
+				benchRequests(b, router, requests)
+			})
+		}
+	}
+}
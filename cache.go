@@ -0,0 +1,194 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+const (
+	lookupCacheShards = 16
+	minShardCapacity  = 8
+	// パスはクライアントが決めるので、長いパスは覚えずに上限をメモリでも抑える
+	// catchAllのルートにはどんな長さのパスも一致する
+	maxCachedPathLen = 256
+)
+
+// 最近の探索結果をメソッドとパスごとに覚えておくLRUキャッシュ
+// ロックの競合を減らすためにパスのハッシュで分割し、それぞれが上限までの要素を持つ
+type lookupCache struct {
+	size   int
+	seed   maphash.Seed
+	shards []cacheShard
+}
+
+type cacheKey struct {
+	method string
+	path   string
+}
+
+// 要素は配列に並べ、配列の中の位置で双方向リストをつなぐ
+// 上限に達した後は追い出した要素の場所を使い回すので、確保し直すことはない
+type cacheShard struct {
+	mu      sync.Mutex
+	index   map[cacheKey]int32
+	entries []cacheEntry
+	head    int32 // 最近使われた要素
+	tail    int32 // 次に追い出される要素
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	table  *methodTable
+	values []string
+	prev   int32
+	next   int32
+}
+
+func newLookupCache(size int) *lookupCache {
+	// 分割しすぎると1つあたりの要素が少なくなり、LRUとして働かなくなる
+	shards := size / minShardCapacity
+	if shards < 1 {
+		shards = 1
+	} else if shards > lookupCacheShards {
+		shards = lookupCacheShards
+	}
+	c := &lookupCache{
+		size:   size,
+		seed:   maphash.MakeSeed(),
+		shards: make([]cacheShard, shards),
+	}
+	for i := range c.shards {
+		capacity := size / shards
+		if i < size%shards {
+			capacity++
+		}
+		s := &c.shards[i]
+		s.index = make(map[cacheKey]int32, capacity)
+		s.entries = make([]cacheEntry, 0, capacity)
+		s.head = -1
+		s.tail = -1
+	}
+	return c
+}
+
+func (c *lookupCache) shard(path string) *cacheShard {
+	return &c.shards[maphash.String(c.seed, path)%uint64(len(c.shards))]
+}
+
+// 見つかった場合、パラメータの値はプールから取り出したバッファに写して返す
+func (c *lookupCache) get(method, path string, pool *sync.Pool, size int) (table *methodTable, ps *Params, ok bool) {
+	s := c.shard(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index[cacheKey{method: method, path: path}]
+	if !ok {
+		return nil, nil, false
+	}
+	s.moveToFront(i)
+
+	e := &s.entries[i]
+	if len(e.values) > 0 {
+		ps = paramsFromPool(pool, size)
+		ps.values = append(ps.values, e.values...)
+	}
+	return e.table, ps, true
+}
+
+func (c *lookupCache) add(method, path string, table *methodTable, ps *Params) {
+	if len(path) > maxCachedPathLen {
+		return
+	}
+	key := cacheKey{method: method, path: path}
+	s := c.shard(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[key]; ok {
+		return
+	}
+
+	var i int32
+	if len(s.entries) < cap(s.entries) {
+		i = int32(len(s.entries))
+		s.entries = s.entries[:i+1]
+	} else {
+		i = s.tail
+		s.unlink(i)
+		delete(s.index, s.entries[i].key)
+	}
+
+	e := &s.entries[i]
+	e.key = key
+	e.table = table
+	e.values = e.values[:0]
+	if ps != nil {
+		e.values = append(e.values, ps.values...)
+	}
+	s.index[key] = i
+	s.pushFront(i)
+}
+
+func (c *lookupCache) clear() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		clear(s.index)
+		for j := range s.entries {
+			s.entries[j].table = nil
+		}
+		s.entries = s.entries[:0]
+		s.head = -1
+		s.tail = -1
+		s.mu.Unlock()
+	}
+}
+
+func (c *lookupCache) len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		n += len(s.index)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (s *cacheShard) unlink(i int32) {
+	e := &s.entries[i]
+	if e.prev >= 0 {
+		s.entries[e.prev].next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next >= 0 {
+		s.entries[e.next].prev = e.prev
+	} else {
+		s.tail = e.prev
+	}
+}
+
+func (s *cacheShard) pushFront(i int32) {
+	e := &s.entries[i]
+	e.prev = -1
+	e.next = s.head
+	if s.head >= 0 {
+		s.entries[s.head].prev = i
+	} else {
+		s.tail = i
+	}
+	s.head = i
+}
+
+func (s *cacheShard) moveToFront(i int32) {
+	if s.head == i {
+		return
+	}
+	s.unlink(i)
+	s.pushFront(i)
+}
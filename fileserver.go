@@ -0,0 +1,182 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"cmp"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+func (r *Router) ServeFiles(path string, root http.FileSystem) {
+	if len(path) < 10 || path[len(path)-10:] != "/*filepath" {
+		panic("path must end with /*filepath in path '" + path + "'")
+	}
+
+	fileServer := newFileServer(root)
+
+	r.GET(path, func(w http.ResponseWriter, req *http.Request, ps Params) {
+		req.URL.Path = ps.ByName("filepath")
+		fileServer.ServeHTTP(w, req)
+	})
+}
+
+func (r *Router) ServeSPA(prefix string, fsys fs.FS, index string) {
+	if len(prefix) < 1 || prefix[0] != '/' {
+		panic("prefix must begin with '/' in prefix '" + prefix + "'")
+	}
+	if !fs.ValidPath(index) || index == "." {
+		panic("invalid index file '" + index + "'")
+	}
+
+	files := newFileServer(http.FS(fsys))
+
+	handle := func(w http.ResponseWriter, req *http.Request, ps Params) {
+		name := path.Clean("/" + ps.ByName("filepath"))
+		if info, err := fs.Stat(fsys, strings.TrimPrefix(name, "/")); err == nil && !info.IsDir() {
+			files.serveFile(w, req, name)
+			return
+		}
+		files.serveFile(w, req, "/"+index)
+	}
+
+	base := strings.TrimSuffix(prefix, "/")
+	r.GET(base+"/*filepath", handle)
+	// "/app"のように末尾の"/"がないパスはcatchAllに一致しないので、別に登録する
+	if base != "" {
+		r.GET(base, handle)
+	}
+}
+
+type precompressedEncoding struct {
+	name string
+	ext  string
+}
+
+var precompressedEncodings = []precompressedEncoding{
+	{name: "br", ext: ".br"},
+	{name: "gzip", ext: ".gz"},
+}
+
+type fileServer struct {
+	root    http.FileSystem
+	handler http.Handler
+}
+
+func newFileServer(root http.FileSystem) *fileServer {
+	return &fileServer{
+		root:    root,
+		handler: http.FileServer(root),
+	}
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Path
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	name = path.Clean(name)
+
+	// ディレクトリとindex.htmlのリダイレクトはhttp.FileServerに任せる
+	if strings.HasSuffix(req.URL.Path, "/") || strings.HasSuffix(name, "/index.html") {
+		s.handler.ServeHTTP(w, req)
+		return
+	}
+
+	if s.servePrecompressed(w, req, name) {
+		return
+	}
+	s.handler.ServeHTTP(w, req)
+}
+
+func (s *fileServer) serveFile(w http.ResponseWriter, req *http.Request, name string) {
+	if s.servePrecompressed(w, req, name) {
+		return
+	}
+
+	f, err := s.root.Open(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+	http.ServeContent(w, req, name, info.ModTime(), f)
+}
+
+func (s *fileServer) servePrecompressed(w http.ResponseWriter, req *http.Request, name string) bool {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	accepted := parseQualityList(req.Header.Get("Accept-Encoding"))
+	for _, enc := range accepted.sortEncodings(precompressedEncodings) {
+		if s.serveEncoded(w, req, name, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fileServer) serveEncoded(w http.ResponseWriter, req *http.Request, name string, enc precompressedEncoding) bool {
+	f, err := s.root.Open(name + enc.ext)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	contentType := s.contentType(name)
+	if contentType == "" {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Encoding", enc.name)
+	http.ServeContent(w, req, name, info.ModTime(), f)
+	return true
+}
+
+func (s *fileServer) contentType(name string) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+
+	// 拡張子から決まらない場合は圧縮前のファイルの先頭から推測する
+	f, err := s.root.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var buf [512]byte
+	n, _ := f.Read(buf[:])
+	return http.DetectContentType(buf[:n])
+}
+
+func (l qualityList) sortEncodings(encodings []precompressedEncoding) []precompressedEncoding {
+	sorted := make([]precompressedEncoding, 0, len(encodings))
+	for _, enc := range encodings {
+		if l.quality(enc.name) > 0 {
+			sorted = append(sorted, enc)
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b precompressedEncoding) int {
+		return cmp.Compare(l.quality(b.name), l.quality(a.name))
+	})
+	return sorted
+}
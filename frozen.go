@@ -0,0 +1,187 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"strings"
+	"sync"
+)
+
+// Freezeで作る読み取り専用の木
+// ノードは幅優先で1つの配列に並べるので、兄弟は常に連続した位置に置かれる
+// ポインタの代わりに配列の中の位置を持ち、パスは1つの文字列に詰めて同じものは共有する
+type frozenTree struct {
+	nodes  []frozenNode
+	labels []byte // labels[i]はnodes[i]の親のindicesでの文字
+	paths  string
+	tables []*methodTable // tables[0]はハンドルなしを表すnil
+}
+
+type frozenNode struct {
+	pathStart        uint32
+	pathEnd          uint32
+	children         uint32 // 最初の子のnodesでの位置
+	childCount       uint16
+	nType            nodeType
+	hasParamChild    bool
+	hasCatchAllChild bool
+	table            uint32
+}
+
+func freezeTree(root *node) *frozenTree {
+	t := &frozenTree{
+		tables: []*methodTable{nil},
+	}
+	var paths strings.Builder
+	interned := make(map[string]uint32)
+
+	queue := []*node{root}
+	t.nodes = append(t.nodes, frozenNode{})
+	t.labels = append(t.labels, 0)
+	for i := 0; i < len(queue); i++ {
+		n := queue[i]
+
+		start, ok := interned[n.path]
+		if !ok {
+			start = uint32(paths.Len())
+			paths.WriteString(n.path)
+			interned[n.path] = start
+		}
+
+		fn := &t.nodes[i]
+		fn.pathStart = start
+		fn.pathEnd = start + uint32(len(n.path))
+		fn.nType = n.nType
+		fn.hasParamChild = n.hasParamChild
+		fn.hasCatchAllChild = n.hasCatchAllChild
+		if n.table != nil {
+			fn.table = uint32(len(t.tables))
+			t.tables = append(t.tables, n.table)
+		}
+
+		fn.children = uint32(len(t.nodes))
+		fn.childCount = uint16(len(n.children))
+		for j, child := range n.children {
+			queue = append(queue, child)
+			t.nodes = append(t.nodes, frozenNode{})
+			t.labels = append(t.labels, n.indices[j])
+		}
+	}
+	t.paths = paths.String()
+	return t
+}
+
+func (t *frozenTree) path(n *frozenNode) string {
+	return t.paths[n.pathStart:n.pathEnd]
+}
+
+func (t *frozenTree) indexOf(n *frozenNode, c byte) int {
+	labels := t.labels[n.children : n.children+uint32(n.childCount)]
+	for i := 0; i < len(labels); i++ {
+		if labels[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *frozenTree) child(n *frozenNode, i int) *frozenNode {
+	return &t.nodes[n.children+uint32(i)]
+}
+
+func (t *frozenTree) retrieve(path, method string, handleHEAD bool, pool *sync.Pool, size int) (table *methodTable, ps *Params) {
+	c := paramsCollector{pool: pool, size: size}
+	return t._retrieve(path, method, handleHEAD, &c)
+}
+
+func (t *frozenTree) retrieve_noparam(path, method string, handleHEAD bool) *methodTable {
+	table, _ := t._retrieve(path, method, handleHEAD, nil)
+	return table
+}
+
+// node.catchAllFallbackと同じ
+func (t *frozenTree) catchAllFallback(path string) *methodTable {
+	n := &t.nodes[0]
+	var fallback *frozenNode
+	for len(path) > 0 {
+		if n.hasCatchAllChild && path[0] == '/' {
+			fallback = t.child(n, t.indexOf(n, '*'))
+		}
+		if i := t.indexOf(n, path[0]); i >= 0 {
+			child := t.child(n, i)
+			childPath := t.path(child)
+			if child.nType == static && len(childPath) <= len(path) && childPath == path[:len(childPath)] {
+				n = child
+				path = path[len(childPath):]
+				continue
+			}
+		}
+		if !n.hasParamChild || path[0] == '/' {
+			break
+		}
+		end := 1
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+		n = t.child(n, 0)
+		path = path[end:]
+	}
+	if fallback == nil {
+		return nil
+	}
+	return t.tables[fallback.table]
+}
+
+// node._retrieveと同じ順序で探索する
+func (t *frozenTree) _retrieve(path, method string, handleHEAD bool, params *paramsCollector) (table *methodTable, ps *Params) {
+	n := &t.nodes[0]
+	var fallback *frozenNode
+	var fallbackPath string
+	var fallbackParams int
+walk:
+	if len(path) == 0 {
+		if table := t.tables[n.table]; table != nil {
+			if method == "" || fallback == nil || table.has(method, handleHEAD) || !t.tables[fallback.table].has(method, handleHEAD) {
+				return table, params.getParams()
+			}
+		}
+		goto fallback
+	}
+	if n.hasCatchAllChild && path[0] == '/' {
+		fallback = t.child(n, t.indexOf(n, '*'))
+		fallbackPath = path
+		fallbackParams = params.len()
+	}
+	if i := t.indexOf(n, path[0]); i >= 0 {
+		child := t.child(n, i)
+		childPath := t.path(child)
+		if child.nType == static && len(childPath) <= len(path) && childPath == path[:len(childPath)] {
+			n = child
+			path = path[len(childPath):]
+			goto walk
+		}
+	}
+	if n.hasParamChild {
+		if path[0] == '/' {
+			goto fallback
+		}
+		child := t.child(n, 0)
+		end := 1
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+		params.add(path[:end])
+		n = child
+		path = path[end:]
+		goto walk
+	}
+fallback:
+	if fallback == nil {
+		return nil, nil
+	}
+	params.truncate(fallbackParams)
+	params.add(fallbackPath)
+	return t.tables[fallback.table], params.getParams()
+}
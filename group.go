@@ -0,0 +1,86 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import "net/http"
+
+// 共通のパスの接頭辞とRouteOptionを持つルートのまとまり
+// グループのRouteOptionはルートごとのものより先に適用される
+type Group struct {
+	r      *Router
+	prefix string
+	opts   []RouteOption
+}
+
+// prefixで始まるルートをまとめて登録するGroupを返す
+func (r *Router) Group(prefix string, opts ...RouteOption) *Group {
+	if len(prefix) > 0 && (prefix[0] != '/' || prefix[len(prefix)-1] == '/') {
+		panic("group prefix must begin with '/' and must not end with '/' in '" + prefix + "'")
+	}
+	return &Group{r: r, prefix: prefix, opts: opts}
+}
+
+// グループの中にさらにグループを作る
+func (g *Group) Group(prefix string, opts ...RouteOption) *Group {
+	sub := g.r.Group(prefix, opts...)
+	sub.prefix = g.prefix + sub.prefix
+	sub.opts = g.options(opts)
+	return sub
+}
+
+// 呼び出すたびに新しいスライスを作り、グループのoptsを書き換えないようにする
+func (g *Group) options(opts []RouteOption) []RouteOption {
+	all := make([]RouteOption, 0, len(g.opts)+len(opts))
+	all = append(all, g.opts...)
+	return append(all, opts...)
+}
+
+func (g *Group) GET(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodGet, path, handle, opts...)
+}
+
+func (g *Group) HEAD(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodHead, path, handle, opts...)
+}
+
+func (g *Group) OPTIONS(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodOptions, path, handle, opts...)
+}
+
+func (g *Group) POST(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodPost, path, handle, opts...)
+}
+
+func (g *Group) PUT(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodPut, path, handle, opts...)
+}
+
+func (g *Group) PATCH(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodPatch, path, handle, opts...)
+}
+
+func (g *Group) DELETE(path string, handle Handle, opts ...RouteOption) {
+	g.Handle(http.MethodDelete, path, handle, opts...)
+}
+
+func (g *Group) Handle(method, path string, handle Handle, opts ...RouteOption) {
+	g.r.Handle(method, g.prefix+path, handle, g.options(opts)...)
+}
+
+func (g *Group) HandleMethods(methods []string, path string, handle Handle, opts ...RouteOption) {
+	g.r.HandleMethods(methods, g.prefix+path, handle, g.options(opts)...)
+}
+
+func (g *Group) Any(path string, handle Handle, opts ...RouteOption) {
+	g.r.Any(g.prefix+path, handle, g.options(opts)...)
+}
+
+func (g *Group) Handler(method, path string, handler http.Handler, opts ...RouteOption) {
+	g.r.Handler(method, g.prefix+path, handler, g.options(opts)...)
+}
+
+func (g *Group) HandlerFunc(method, path string, handler http.HandlerFunc, opts ...RouteOption) {
+	g.r.HandlerFunc(method, g.prefix+path, handler, g.options(opts)...)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// GETのハンドルをHEADとして実行するためのResponseWriter
+// ボディは捨てるが、書き込まれたバイト数からContent-Lengthを補う
+type headResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// リクエストのたびにヒープに確保しないように使い回す
+var headResponseWriterPool = sync.Pool{
+	New: func() interface{} {
+		return new(headResponseWriter)
+	},
+}
+
+func getHeadResponseWriter(w http.ResponseWriter) *headResponseWriter {
+	hw := headResponseWriterPool.Get().(*headResponseWriter)
+	hw.ResponseWriter = w
+	return hw
+}
+
+func putHeadResponseWriter(hw *headResponseWriter) {
+	*hw = headResponseWriter{}
+	headResponseWriterPool.Put(hw)
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.written += int64(len(b))
+	return len(b), nil
+}
+
+func (w *headResponseWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if w.written > 0 && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.FormatInt(w.written, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// ストリーミングするGETのハンドルもHEADとして動くように、Flushは受け付ける
+// ヘッダーはContent-Lengthを決めるまで送れないので、何もしない
+func (w *headResponseWriter) Flush() {}
+
+// http.ResponseControllerが元のResponseWriterの機能を使えるようにする
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
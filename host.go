@@ -0,0 +1,219 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+)
+
+// patternに一致するHostへのリクエストだけを扱うRouterを返す
+// ":tenant.example.com"のようにラベルをパラメータにでき、値はパスのパラメータの後に並ぶ
+// リクエストのポートは照合に使わないので、"localhost:8080"は"localhost"として登録する
+// 返すRouterはHostを呼んだ時点の設定を引き継ぐ。どのホストにも一致しない場合はrのルートが使われる
+func (r *Router) Host(pattern string) *Router {
+	if r.frozen != nil {
+		panic("cannot add host '" + pattern + "' to a frozen router")
+	}
+	if pattern == "" || strings.ContainsAny(pattern, "/*") {
+		panic("invalid host pattern '" + pattern + "'")
+	}
+	// normalizeHostはリクエストのホストからポートを除くので、パターンのポートも除く
+	// ラベルの先頭になく、数字だけが続く":"をポートの区切りとみなす
+	if i := strings.LastIndexByte(pattern, ':'); i > 0 && pattern[i-1] != '.' && isPort(pattern[i+1:]) {
+		pattern = pattern[:i]
+	}
+	labels := strings.Split(pattern, ".")
+	for i, label := range labels {
+		if strings.IndexByte(label, ':') > 0 {
+			panic("invalid host pattern '" + pattern + "'")
+		}
+		if !strings.HasPrefix(label, ":") {
+			labels[i] = strings.ToLower(label)
+		}
+	}
+	pattern = strings.Join(labels, ".")
+
+	// パラメータを含まないホストは木に入れず、mapで引く
+	// 木ではパラメータと静的なラベルを兄弟にできないので、"api.example.com"と
+	// ":tenant.example.com"を両方登録できるようにするためでもある
+	if strings.IndexByte(pattern, ':') < 0 {
+		if sub, ok := r.staticHosts[pattern]; ok {
+			return sub
+		}
+		if r.staticHosts == nil {
+			r.staticHosts = make(map[string]*Router)
+		}
+		sub := r.newHostRouter(nil)
+		r.staticHosts[pattern] = sub
+		return sub
+	}
+
+	if r.hosts == nil {
+		r.hosts = new(node)
+		r.hostRouters = make(map[*methodTable]*Router)
+	}
+	table := r.hosts.addRoute(hostPath(pattern))
+	if sub, ok := r.hostRouters[table]; ok {
+		return sub
+	}
+	r.updateMaxParams(table)
+
+	sub := r.newHostRouter(table.names)
+	r.hostRouters[table] = sub
+	return sub
+}
+
+// sが空でない数字の列か
+func isPort(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Router) newHostRouter(hostNames []string) *Router {
+	sub := &Router{
+		hostNames:              hostNames,
+		PanicHandler:           r.PanicHandler,
+		HandleOPTIONS:          r.HandleOPTIONS,
+		HandleMethodNotAllowed: r.HandleMethodNotAllowed,
+		HandleHEAD:             r.HandleHEAD,
+		SaveMatchedRoutePath:   r.SaveMatchedRoutePath,
+		RedirectFixedPath:      r.RedirectFixedPath,
+		ServeCleanedPath:       r.ServeCleanedPath,
+		PathCleaning:           r.PathCleaning,
+		UseRawPath:             r.UseRawPath,
+		UnescapePathValues:     r.UnescapePathValues,
+		StaticFastPath:         r.StaticFastPath,
+		PoisonParams:           r.PoisonParams,
+		Versioning:             r.Versioning,
+		deprecations:           maps.Clone(r.deprecations),
+	}
+	if r.cache != nil {
+		sub.cache = newLookupCache(r.cache.size)
+	}
+	return sub
+}
+
+// ホスト名の"."を"/"に置き換えて、パスと同じ木で扱えるようにする
+func hostPath(host string) string {
+	b := make([]byte, 0, len(host)+1)
+	b = append(b, '/')
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if c == '.' {
+			c = '/'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// hostPathで"/"に置き換えて登録した木を、hostの"."を"/"と読み替えながらたどる
+// 探索のたびにhostPathで文字列を作らないので、メモリを確保しない
+// ホストのパターンにはcatchAllがないので、行き止まりになったら見つからなかったものとする
+func (n *node) retrieveHost(host string, params *paramsCollector) *methodTable {
+	// 木のパスは"/"で始まるので、hostの前に"/"があるものとして位置を数える
+	i, end := 0, len(host)+1
+walk:
+	if i == end {
+		return n.table
+	}
+	c := hostByte(host, i)
+	if k := n.indexOf(c); k >= 0 {
+		child := n.children[k]
+		if child.nType == static && hasHostPrefix(host, i, child.path) {
+			n = child
+			i += len(child.path)
+			goto walk
+		}
+	}
+	if n.hasParamChild && c != '/' {
+		// パラメータの子は兄弟を持たない
+		child := n.children[0]
+		j := i + 1
+		for j < end && hostByte(host, j) != '/' {
+			j++
+		}
+		params.add(host[i-1 : j-1])
+		n = child
+		i = j
+		goto walk
+	}
+	return nil
+}
+
+// hostPath(host)[i]を、文字列を作らずに返す
+func hostByte(host string, i int) byte {
+	if i == 0 {
+		return '/'
+	}
+	if c := host[i-1]; c != '.' {
+		return c
+	}
+	return '/'
+}
+
+func hasHostPrefix(host string, i int, prefix string) bool {
+	if i+len(prefix) > len(host)+1 {
+		return false
+	}
+	for k := 0; k < len(prefix); k++ {
+		if hostByte(host, i+k) != prefix[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// ポートと末尾の"."を除き、小文字にそろえる
+// 大文字を含まない場合は新しい文字列を作らない
+func normalizeHost(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && strings.IndexByte(host[i:], ']') < 0 {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	for i := 0; i < len(host); i++ {
+		if 'A' <= host[i] && host[i] <= 'Z' {
+			return strings.ToLower(host)
+		}
+	}
+	return host
+}
+
+// Hostで登録したRouterのうちreq.Hostに一致するものに処理を任せる
+func (r *Router) serveHost(w http.ResponseWriter, req *http.Request) bool {
+	host := normalizeHost(req.Host)
+	if sub, ok := r.staticHosts[host]; ok {
+		sub.serve(w, req, nil)
+		return true
+	}
+	if r.hosts == nil {
+		return false
+	}
+
+	c := paramsCollector{pool: &r.paramsPool, size: r.maxParams}
+	table := r.hosts.retrieveHost(host, &c)
+	ps := c.getParams()
+	if table == nil {
+		r.putParams(ps)
+		return false
+	}
+
+	var hostValues []string
+	if ps != nil {
+		hostValues = ps.values
+	}
+	r.hostRouters[table].serve(w, req, hostValues)
+	r.putParams(ps)
+	return true
+}
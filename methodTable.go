@@ -0,0 +1,266 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 同じメソッドとパスに条件の異なるハンドルを複数登録できる
+// 条件のないハンドルは最後に置かれ、どの条件にも一致しない場合に使われる
+type candidate struct {
+	handle    Handle
+	matchers  []matcher
+	produces  string // Producesで指定したメディアタイプ
+	version   int    // APIVersionで指定したバージョン
+	vary      bool   // 同じメソッドにProducesを指定した候補があり、Acceptでハンドルが変わる
+	versioned bool   // 同じメソッドにAPIVersionを指定した候補がある
+}
+
+type methodHandle struct {
+	method     string
+	candidates []candidate
+}
+
+// 1つのパスに登録されたメソッドごとのハンドル
+// handlesはメソッド名でソートしておく
+type methodTable struct {
+	handles       []methodHandle
+	anyCandidates []candidate
+	allow         allowHeader
+	allowOnce     sync.Once // allowは登録のたびではなく、必要になったときに作る
+	merged        atomic.Pointer[mergedAllow]
+	names         []string // SaveMatchedRoutePathの分も含めたパラメータの名前
+}
+
+func (t *methodTable) add(method string, c candidate) {
+	i, found := slices.BinarySearchFunc(t.handles, method, func(mh methodHandle, method string) int {
+		return strings.Compare(mh.method, method)
+	})
+	if !found {
+		t.handles = slices.Insert(t.handles, i, methodHandle{method: method})
+	}
+	t.handles[i].candidates = addCandidate(t.handles[i].candidates, c)
+	// Allowが変わるのはメソッドが増えたときだけ
+	if !found {
+		t.resetAllow()
+	}
+}
+
+func (t *methodTable) addAny(c candidate) {
+	first := len(t.anyCandidates) == 0
+	t.anyCandidates = addCandidate(t.anyCandidates, c)
+	if first {
+		t.resetAllow()
+	}
+}
+
+// 条件の多い候補ほど前に置き、条件の数が同じ場合は先に登録したものを優先する
+// 条件のないハンドルは1つだけで、登録し直すと置き換える
+// 条件がなくProducesとバージョンが同じ候補は、登録し直すと置き換える
+func addCandidate(candidates []candidate, c candidate) []candidate {
+	replaced := false
+	if len(c.matchers) == 0 {
+		for i := range candidates {
+			if len(candidates[i].matchers) == 0 && candidates[i].produces == c.produces && candidates[i].version == c.version {
+				candidates[i] = c
+				replaced = true
+				break
+			}
+		}
+	}
+	if !replaced {
+		i := 0
+		for i < len(candidates) && len(candidates[i].matchers) >= len(c.matchers) && !candidates[i].isDefault() {
+			i++
+		}
+		candidates = slices.Insert(candidates, i, c)
+	}
+
+	vary, versioned := false, false
+	for _, c := range candidates {
+		vary = vary || c.produces != ""
+		versioned = versioned || c.version != 0
+	}
+	for i := range candidates {
+		candidates[i].vary = vary
+		candidates[i].versioned = versioned
+	}
+	return candidates
+}
+
+// Producesもバージョンも指定していない、条件のない候補
+func (c *candidate) isDefault() bool {
+	return len(c.matchers) == 0 && c.produces == "" && c.version == 0
+}
+
+// SaveMatchedRoutePathを有効にして登録し直した場合は、名前が1つ増えたものに置き換わる
+func (t *methodTable) setParamNames(names []string) {
+	if len(names) > len(t.names) {
+		t.names = names
+	}
+}
+
+// 登録した後で最初に必要になったときに一度だけAllowの値を作る
+// 登録はリクエストの処理と並行して行わないので、ここで作り直しても競合しない
+func (t *methodTable) allowHeader() *allowHeader {
+	t.allowOnce.Do(t.updateAllow)
+	return &t.allow
+}
+
+func (t *methodTable) resetAllow() {
+	t.allowOnce = sync.Once{}
+	t.merged.Store(nil)
+}
+
+// catchAllに戻るパスで使う、fallbackのメソッドも合わせたAllowの値
+// sourceは作ったときのfallbackのAllowの値で、fallbackにメソッドが増えると作り直された別のスライスになる
+type mergedAllow struct {
+	fallback *methodTable
+	source   []string
+	allow    allowHeader
+}
+
+// リクエストのたびに組み立てないように、合わせた値をfallbackごとに覚えておく
+func (t *methodTable) allowWithFallback(fallback *methodTable) *allowHeader {
+	own := t.allowHeader()
+	other := fallback.allowHeader()
+	if m := t.merged.Load(); m != nil && m.fallback == fallback && sameSlice(m.source, other.value) {
+		return &m.allow
+	}
+	m := &mergedAllow{
+		fallback: fallback,
+		source:   other.value,
+		allow: allowHeader{
+			value:     mergeAllow(own.value, other.value),
+			valueHEAD: mergeAllow(own.valueHEAD, other.valueHEAD),
+		},
+	}
+	t.merged.Store(m)
+	return &m.allow
+}
+
+func sameSlice(a, b []string) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+func (t *methodTable) updateAllow() {
+	methods := make([]string, 0, len(t.handles))
+	for _, mh := range t.handles {
+		methods = append(methods, mh.method)
+	}
+	t.allow = newAllowHeader(methods, len(t.anyCandidates) > 0)
+}
+
+func (t *methodTable) candidates(method string) []candidate {
+	for _, mh := range t.handles {
+		if mh.method == method {
+			return mh.candidates
+		}
+	}
+	return nil
+}
+
+// headFromGetはHEADリクエストにGETのハンドルを使う場合にtrueになる
+// HEADにGETのハンドルを使うのは、HEADにもAnyにも使える候補がない場合だけにする
+// メソッドにハンドルはあるが条件に一致しなかった場合は、返すべきステータスをstatusに入れる
+// versionはリクエストが求めるAPIのバージョンで、0の場合は最新のものを使う
+func (t *methodTable) lookup(req *http.Request, version int, handleHEAD bool) (c *candidate, headFromGet bool, status int) {
+	candidates := t.candidates(req.Method)
+	if candidates != nil {
+		if c, status = selectCandidate(candidates, req, version); c != nil {
+			return c, false, 0
+		}
+	}
+	if len(t.anyCandidates) > 0 {
+		var anyStatus int
+		if c, anyStatus = selectCandidate(t.anyCandidates, req, version); c != nil {
+			return c, false, 0
+		}
+		status = max(status, anyStatus)
+	}
+	if candidates == nil && req.Method == http.MethodHead && handleHEAD {
+		if candidates = t.candidates(http.MethodGet); candidates != nil {
+			var getStatus int
+			if c, getStatus = selectCandidate(candidates, req, version); c != nil {
+				return c, true, 0
+			}
+			status = max(status, getStatus)
+		}
+	}
+	return nil, false, status
+}
+
+// 条件に一致した候補のうち、Producesを指定したものはAcceptの品質が最も高いものを選ぶ
+// どれもAcceptに合わない場合は、それより後の条件に一致する候補を使い、なければ406を返す
+// 一致しなかった候補のステータスは最も大きいものを返すので、
+// Content-Type以外の条件に一致した候補があれば415になる
+func selectCandidate(candidates []candidate, req *http.Request, version int) (*candidate, int) {
+	target := 0
+	if candidates[0].versioned {
+		var ok bool
+		if target, ok = targetVersion(candidates, version); !ok {
+			return nil, http.StatusNotFound
+		}
+	}
+
+	status := 0
+	var accept qualityList
+	var best *candidate
+	bestQuality := 0.0
+	negotiated := false
+	for i := range candidates {
+		c := &candidates[i]
+		if c.version != target {
+			continue
+		}
+		if failed := c.match(req); failed != 0 {
+			status = max(status, failed)
+			continue
+		}
+		if c.produces == "" {
+			if best != nil {
+				return best, 0
+			}
+			return c, 0
+		}
+		if !negotiated {
+			accept = parseAccept(req.Header.Get("Accept"))
+			negotiated = true
+		}
+		if q := accept.mediaQuality(c.produces); q > bestQuality {
+			best = c
+			bestQuality = q
+		}
+	}
+	if best != nil {
+		return best, 0
+	}
+	if negotiated {
+		return nil, http.StatusNotAcceptable
+	}
+	return nil, status
+}
+
+func (c *candidate) match(req *http.Request) int {
+	for _, m := range c.matchers {
+		if !m.match(req) {
+			return m.status
+		}
+	}
+	return 0
+}
+
+// 条件を見ずに、メソッドに使えるハンドルがあるかを返す
+func (t *methodTable) has(method string, handleHEAD bool) bool {
+	if t.candidates(method) != nil || len(t.anyCandidates) > 0 {
+		return true
+	}
+	return method == http.MethodHead && handleHEAD && t.candidates(http.MethodGet) != nil
+}
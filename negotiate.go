@@ -0,0 +1,99 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 同じメソッドとパスに複数のハンドルを登録し、AcceptでmediaTypeを受け付けるものを選ぶ
+// Producesを指定したハンドルがどれもAcceptに合わない場合は、指定していないハンドルがあればそれを使い、
+// なければ406を返す。レスポンスにはVary: Acceptを付ける
+func Produces(mediaType string) RouteOption {
+	mediaType = strings.ToLower(mediaType)
+	return func(c *routeConfig) {
+		c.produces = mediaType
+	}
+}
+
+type qualityValue struct {
+	value   string
+	quality float64
+}
+
+type qualityList []qualityValue
+
+func parseQualityList(header string) qualityList {
+	var list qualityList
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, val, ok := strings.Cut(param, "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				q = f
+			}
+		}
+		list = append(list, qualityValue{value: value, quality: q})
+	}
+	return list
+}
+
+func (l qualityList) quality(value string) float64 {
+	wildcard := -1.0
+	for _, qv := range l {
+		if qv.value == value {
+			return qv.quality
+		}
+		if qv.value == "*" {
+			wildcard = qv.quality
+		}
+	}
+	if wildcard < 0 {
+		return 0
+	}
+	return wildcard
+}
+
+// Acceptがない場合はどのメディアタイプも受け付ける
+func parseAccept(header string) qualityList {
+	if header == "" {
+		return qualityList{{value: "*/*", quality: 1}}
+	}
+	return parseQualityList(header)
+}
+
+// Acceptの範囲のうちmediaTypeに一致する最も詳しいものの品質を返す
+// "text/html"、"text/*"、"*/*"の順に詳しい
+func (l qualityList) mediaQuality(mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q := 0.0
+	specificity := -1
+	for _, qv := range l {
+		s := -1
+		switch {
+		case qv.value == mediaType:
+			s = 2
+		case strings.HasSuffix(qv.value, "/*") && qv.value[:len(qv.value)-2] == typ:
+			s = 1
+		case qv.value == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q = qv.quality
+			specificity = s
+		}
+	}
+	return q
+}
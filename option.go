@@ -0,0 +1,107 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var anyMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodTrace,
+}
+
+// ルート登録時に組み立てておくAllowヘッダーの値
+// レスポンスヘッダーにそのまま代入できるように[]stringで持つ
+type allowHeader struct {
+	value     []string
+	valueHEAD []string
+}
+
+func newAllowHeader(methods []string, hasAny bool) allowHeader {
+	allowed := make([]string, 0, len(methods)+len(anyMethods)+2)
+	for _, method := range methods {
+		if method == http.MethodOptions {
+			continue
+		}
+		allowed = append(allowed, method)
+	}
+	if hasAny {
+		allowed = append(allowed, anyMethods...)
+	}
+
+	a := allowHeader{value: joinAllowed(slices.Clone(allowed))}
+	if slices.Contains(allowed, http.MethodGet) {
+		a.valueHEAD = joinAllowed(append(allowed, http.MethodHead))
+	} else {
+		a.valueHEAD = a.value
+	}
+	return a
+}
+
+func (a *allowHeader) get(handleHEAD bool) []string {
+	if handleHEAD {
+		return a.valueHEAD
+	}
+	return a.value
+}
+
+func joinAllowed(allowed []string) []string {
+	if len(allowed) > 0 {
+		allowed = append(allowed, http.MethodOptions)
+		slices.Sort(allowed)
+		allowed = slices.Compact(allowed)
+		return []string{strings.Join(allowed, ", ")}
+	}
+
+	return nil
+}
+
+// 2つのAllowヘッダーの値を合わせる
+// bのメソッドがすべてaに含まれていれば、aをそのまま返す
+func mergeAllow(a, b []string) []string {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		return b
+	}
+	methods := strings.Split(a[0], ", ")
+	added := false
+	for _, method := range strings.Split(b[0], ", ") {
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+			added = true
+		}
+	}
+	if !added {
+		return a
+	}
+	return joinAllowed(methods)
+}
+
+var (
+	errorContentType     = []string{"text/plain; charset=utf-8"}
+	errorContentOptions  = []string{"nosniff"}
+	methodNotAllowedBody = []byte(http.StatusText(http.StatusMethodNotAllowed) + "\n")
+)
+
+// http.Errorと同じレスポンスを、ヘッダーの値と本文を使い回して書き込む
+func methodNotAllowed(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Length")
+	h["Content-Type"] = errorContentType
+	h["X-Content-Type-Options"] = errorContentOptions
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write(methodNotAllowedBody)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// CleanPathで行う正規化の設定
+type CleanOptions struct {
+	// 元のパスにあれば末尾の"/"を残す。falseの場合はpath.Cleanと同じく取り除く
+	PreserveTrailingSlash bool
+	// 大文字を小文字にそろえる
+	Lowercase bool
+}
+
+// pを正規化したパスを返す
+// 連続する"/"をまとめ、"."と".."を解決し、先頭に"/"がなければ付ける
+// PreserveTrailingSlashを指定すればpath.Cleanと違って末尾の"/"を残せる
+// pがすでに正規化されていればメモリを確保しない
+func CleanPath(p string, opts CleanOptions) string {
+	if p == "" {
+		return "/"
+	}
+
+	n := len(p)
+	b := lazybuf{s: p, lower: opts.Lowercase}
+	b.append('/')
+	r := 0
+	if p[0] == '/' {
+		r = 1
+	}
+
+	for r < n {
+		switch {
+		case p[r] == '/':
+			r++
+		case p[r] == '.' && (r+1 == n || p[r+1] == '/'):
+			r++
+		case p[r] == '.' && p[r+1] == '.' && (r+2 == n || p[r+2] == '/'):
+			r += 2
+			// 1つ前の要素を取り除く。ルートより上には戻らない
+			if b.w > 1 {
+				b.w--
+				for b.w > 1 && b.index(b.w) != '/' {
+					b.w--
+				}
+			}
+		default:
+			if b.w > 1 {
+				b.append('/')
+			}
+			for ; r < n && p[r] != '/'; r++ {
+				b.append(p[r])
+			}
+		}
+	}
+
+	if opts.PreserveTrailingSlash && p[n-1] == '/' && b.w > 1 {
+		b.append('/')
+	}
+	return b.string()
+}
+
+// 書き込む内容が元の文字列と一致している間はバッファを確保しない
+type lazybuf struct {
+	s     string
+	buf   []byte
+	w     int
+	lower bool
+}
+
+func (b *lazybuf) index(i int) byte {
+	if b.buf != nil {
+		return b.buf[i]
+	}
+	return b.s[i]
+}
+
+func (b *lazybuf) append(c byte) {
+	if b.lower && 'A' <= c && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	if b.buf == nil {
+		if b.w < len(b.s) && b.s[b.w] == c {
+			b.w++
+			return
+		}
+		// 先頭に"/"を付け足す場合があるので1バイト多く確保する
+		b.buf = make([]byte, len(b.s)+1)
+		copy(b.buf, b.s[:b.w])
+	}
+	b.buf[b.w] = c
+	b.w++
+}
+
+func (b *lazybuf) string() string {
+	if b.buf == nil {
+		return b.s[:b.w]
+	}
+	return string(b.buf[:b.w])
+}
+
+// パスだけを差し替えたリクエストの浅いコピーを返す
+func withPath(req *http.Request, p string, raw bool) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	setURLPath(&u, p, raw)
+	r.URL = &u
+	return r
+}
+
+// rawがtrueの場合、pはエスケープされたままのパスとして扱う
+func setURLPath(u *url.URL, p string, raw bool) {
+	if !raw {
+		u.Path = p
+		u.RawPath = ""
+		return
+	}
+	u.RawPath = p
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		u.Path = unescaped
+	} else {
+		u.Path = p
+	}
+}
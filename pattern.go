@@ -0,0 +1,230 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// "/static/"のように"{$}"で終わらないパターンは、http.ServeMuxと同じく配下のパスすべてに一致させる
+// そのために付け足すcatchAllの名前で、ServeMuxの形式では参照できない
+const subtreeParam = "..."
+
+// http.ServeMuxと同じ"[METHOD ][HOST]/[PATH]"形式のパターンでルートを登録する
+// "{id}"は":id"に、"{path...}"は"*path"に読み替える。"{path...}"の値はServeMuxと同じく先頭の"/"を含まない
+// メソッドを省略した場合はAnyとして、ホストを指定した場合はHostで作ったRouterに登録する
+// ServeMuxと同じく、GETのパターンはHEADにも一致する
+//
+// ServeMuxは"/users/{id}"と"/users/new"のように同じ位置にワイルドカードと静的な要素がある
+// パターンを、より具体的な方を優先して両方登録できるが、このRouterの木ではできない
+// そのような組み合わせは、両方のパターンを示すメッセージでpanicする
+func (r *Router) HandlePattern(pattern string, handle Handle, opts ...RouteOption) {
+	if handle == nil {
+		panic("handle must not be nil")
+	}
+	method, host, path, catchAll := parsePattern(pattern)
+	if catchAll >= 0 {
+		handle = trimCatchAllSlash(handle, catchAll)
+	}
+
+	target := r
+	if host != "" {
+		target = r.Host(host)
+	}
+	target.handlePattern(pattern, method, path, handle, opts)
+	target.patterns = append(target.patterns, muxPattern{pattern: pattern, path: path})
+}
+
+// HandlePatternで登録したパターンと、読み替えた後のパス
+// 木で衝突したときに、どのパターンと衝突したのかを示すために使う
+type muxPattern struct {
+	pattern string
+	path    string
+}
+
+func (r *Router) handlePattern(pattern, method, path string, handle Handle, opts []RouteOption) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if _, ok := rcv.(conflictPanic); ok {
+				panic(r.patternConflict(pattern, path))
+			}
+			panic(rcv)
+		}
+	}()
+
+	root, subtree := strings.CutSuffix(path, "*"+subtreeParam)
+	if subtree {
+		handle = r.hideSubtreeParam(path, handle)
+	}
+
+	var methods []string
+	if method == "" {
+		r.Any(path, handle, opts...)
+	} else {
+		methods = []string{method}
+		if method == http.MethodGet {
+			opts = append(slices.Clip(opts), func(c *routeConfig) {
+				c.implicitHEAD = true
+			})
+			methods = append(methods, http.MethodHead)
+		}
+		r.Handle(method, path, handle, opts...)
+	}
+
+	// ServeMuxと同じく、"/static"は"/static/"へリダイレクトする
+	// "/static"を明示的に登録したメソッドには登録しない
+	// TrailingSlashRedirectはGET以外に308を返すので、ハンドルをそのまま登録するTrailingSlashAcceptを使う
+	if subtree {
+		config := newRouteConfig(opts)
+		r.addTrailingSlashRoute(methods, root, append(paramNames(root), r.hostNames...), config.candidate(redirectSubtreeRoot), TrailingSlashAccept)
+	}
+}
+
+// ServeMuxはメソッドにかかわらず301でリダイレクトする
+func redirectSubtreeRoot(w http.ResponseWriter, req *http.Request, _ Params) {
+	u := *req.URL
+	u.Path += "/"
+	if u.RawPath != "" {
+		u.RawPath += "/"
+	}
+	http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+}
+
+// 配下のパスに一致させるために付け足したcatchAllは、ServeMuxの形式で書かれたハンドルには見せない
+// ハンドルに渡すParamsの名前と値から取り除く
+func (r *Router) hideSubtreeParam(path string, handle Handle) Handle {
+	names := paramNames(path)
+	i := len(names) - 1
+	names = append(names[:i], r.hostNames...)
+	if r.SaveMatchedRoutePath {
+		names = append(names, MatchedRoutePathParam)
+	}
+	return func(w http.ResponseWriter, req *http.Request, ps Params) {
+		if i < len(ps.values) {
+			ps.values = append(ps.values[:i], ps.values[i+1:]...)
+		}
+		ps.names = names
+		handle(w, req, ps)
+	}
+}
+
+func (r *Router) patternConflict(pattern, path string) string {
+	const reason = ": a wildcard and a static segment at the same position are not supported, unlike http.ServeMux"
+	for _, p := range r.patterns {
+		if pathsConflict(p.path, path) {
+			return "pattern '" + pattern + "' conflicts with pattern '" + p.pattern + "'" + reason
+		}
+	}
+	return "pattern '" + pattern + "' conflicts with an existing route" + reason
+}
+
+// 2つのパスを新しい木に登録して、衝突するかを調べる
+func pathsConflict(a, b string) (conflict bool) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			conflict = true
+		}
+	}()
+	n := new(node)
+	n.addRoute(a)
+	n.addRoute(b)
+	return false
+}
+
+// http.Handlerを登録するHandlePattern
+// パラメータはParamsFromContextで取り出せる
+func (r *Router) HandlerPattern(pattern string, handler http.Handler, opts ...RouteOption) {
+	r.HandlePattern(pattern, handlerHandle(handler), opts...)
+}
+
+// パターンを分解し、パスを":"と"*"を使う形に書き換える
+// catchAllは"{name...}"のパラメータの位置で、ない場合は-1になる
+func parsePattern(pattern string) (method, host, path string, catchAll int) {
+	rest := pattern
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		method = pattern[:i]
+		rest = strings.TrimLeft(pattern[i:], " \t")
+	}
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		panic("pattern must contain a path in '" + pattern + "'")
+	}
+	host, rest = rest[:i], rest[i:]
+
+	catchAll = -1
+	var names []string
+	var b strings.Builder
+	exact := false
+	segments := strings.Split(rest[1:], "/")
+	for i, segment := range segments {
+		b.WriteByte('/')
+		last := i == len(segments)-1
+		switch {
+		case !strings.ContainsAny(segment, "{}"):
+			if strings.ContainsAny(segment, ":*") {
+				panic("pattern must not contain ':' or '*' in '" + pattern + "'")
+			}
+			b.WriteString(segment)
+		case segment == "{$}":
+			if !last {
+				panic("{$} must be at the end of the pattern in '" + pattern + "'")
+			}
+			exact = true
+		case segment[0] == '{' && segment[len(segment)-1] == '}':
+			name := segment[1 : len(segment)-1]
+			if strings.HasSuffix(name, "...") {
+				if !last {
+					panic("{" + name + "} must be at the end of the pattern in '" + pattern + "'")
+				}
+				name = strings.TrimSuffix(name, "...")
+				catchAll = len(names)
+				b.WriteByte('*')
+			} else {
+				b.WriteByte(':')
+			}
+			if !isIdentifier(name) {
+				panic("invalid wildcard name '" + name + "' in '" + pattern + "'")
+			}
+			if slices.Contains(names, name) {
+				panic("duplicate wildcard name '" + name + "' in '" + pattern + "'")
+			}
+			names = append(names, name)
+			b.WriteString(name)
+		default:
+			panic("wildcard must be a whole path segment in '" + pattern + "'")
+		}
+	}
+
+	path = b.String()
+	if !exact && catchAll < 0 && path[len(path)-1] == '/' {
+		path += "*" + subtreeParam
+	}
+	return method, host, path, catchAll
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// catchAllの値は先頭に"/"を含むので、ServeMuxに合わせて取り除いてからhandleを呼ぶ
+func trimCatchAllSlash(handle Handle, i int) Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps Params) {
+		if i < len(ps.values) {
+			ps.values[i] = strings.TrimPrefix(ps.values[i], "/")
+		}
+		handle(w, req, ps)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+//go:build race
+
+package zerorouter
+
+// レースディテクタはsync.Poolの要素を無作為に捨てるので、メモリ確保の回数を確かめるテストを飛ばす
+const raceEnabled = true
@@ -0,0 +1,104 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fromに一致するリクエストを、toにパラメータの値を埋め込んだパスへリダイレクトする
+// "/old/:id/*rest"から"/new/:id/*rest"のように、toではfromのパラメータを名前で参照できる
+// クエリ文字列はリダイレクト先に引き継ぐ
+func (r *Router) Redirect(from, to string, code int) {
+	if code < 300 || code > 399 {
+		panic("invalid redirect code " + strconv.Itoa(code))
+	}
+	if len(to) < 1 || to[0] != '/' {
+		panic("redirect target must begin with '/' in path '" + to + "'")
+	}
+	target := parsePathTemplate(to, paramNames(from))
+	r.Any(from, func(w http.ResponseWriter, req *http.Request, ps Params) {
+		u := url.URL{RawQuery: req.URL.RawQuery}
+		// UnescapePathValuesで戻した値は、エスケープし直してから埋め込む
+		raw := r.UseRawPath
+		setURLPath(&u, collapseLeadingSlashes(target.expand(ps, raw && r.UnescapePathValues)), raw)
+		http.Redirect(w, req, u.String(), code)
+	})
+}
+
+// "//evil.com"のように"/"が続くと、ブラウザは別のホストへのURLとして扱う
+// catchAllの値は先頭に"/"を含むので、値の中の"/"が続いていれば"/"1つにまとめる
+func collapseLeadingSlashes(p string) string {
+	if len(p) < 2 || p[1] != '/' {
+		return p
+	}
+	return "/" + strings.TrimLeft(p, "/")
+}
+
+// 固定の文字列とパラメータの参照を交互に並べたもの
+// RedirectとRewriteの書き換え先に使う
+type pathTemplate struct {
+	literals []string
+	names    []string
+	catchAll []bool
+}
+
+func parsePathTemplate(to string, names []string) pathTemplate {
+	var t pathTemplate
+	for {
+		wildcard, i, valid := findWildcard(to)
+		if i < 0 {
+			break
+		}
+		if !valid {
+			panic("invalid wildcard in target '" + to + "'")
+		}
+		// catchAllの値は先頭の"/"を含むので、"/*name"をまとめて置き換える
+		name := strings.TrimLeft(wildcard, "/*:")
+		if !slices.Contains(names, name) {
+			panic("target refers to unknown parameter '" + name + "'")
+		}
+		t.literals = append(t.literals, to[:i])
+		t.names = append(t.names, name)
+		t.catchAll = append(t.catchAll, wildcard[0] == '/')
+		to = to[i+len(wildcard):]
+	}
+	t.literals = append(t.literals, to)
+	return t
+}
+
+// escapeがtrueの場合、値をパスの1要素としてエスケープする
+// catchAllの値は複数の要素からなるので、"/"はそのまま残す
+func (t pathTemplate) expand(ps Params, escape bool) string {
+	if len(t.names) == 0 {
+		return t.literals[0]
+	}
+	var b strings.Builder
+	for i, name := range t.names {
+		b.WriteString(t.literals[i])
+		v := ps.ByName(name)
+		if escape {
+			v = escapePathValue(v, t.catchAll[i])
+		}
+		b.WriteString(v)
+	}
+	b.WriteString(t.literals[len(t.names)])
+	return b.String()
+}
+
+func escapePathValue(v string, keepSlash bool) string {
+	if !keepSlash {
+		return url.PathEscape(v)
+	}
+	segments := strings.Split(v, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
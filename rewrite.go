@@ -0,0 +1,63 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"context"
+	"net/http"
+)
+
+type originalPathKey struct{}
+
+var OriginalPathKey = originalPathKey{}
+
+// Rewriteで書き換えられる前のパスを返す
+// 書き換えられていない場合は空文字列を返す
+func OriginalPathFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(OriginalPathKey).(string)
+	return p
+}
+
+// fromに一致するパスを、ルートを探す前にtoへ書き換える
+// リダイレクトせずに"/u/:name"を"/users/:name"のルートで処理するときに使う
+// 書き換えは1度だけで、書き換えた後のパスにさらにRewriteを適用することはない
+func (r *Router) Rewrite(from, to string) {
+	if r.frozen != nil {
+		panic("cannot add rewrite '" + from + "' to a frozen router")
+	}
+	if len(from) < 1 || from[0] != '/' {
+		panic("path must begin with '/' in path '" + from + "'")
+	}
+	if len(to) < 1 || to[0] != '/' {
+		panic("rewrite target must begin with '/' in path '" + to + "'")
+	}
+	target := parsePathTemplate(to, paramNames(from))
+
+	if r.rewrites == nil {
+		r.rewrites = new(node)
+		r.rewriteTargets = make(map[*methodTable]pathTemplate)
+	}
+	table := r.rewrites.addRoute(from)
+	r.rewriteTargets[table] = target
+	r.updateMaxParams(table)
+}
+
+// 書き換えた場合は、元のパスをcontextに入れた新しいリクエストを返す
+func (r *Router) rewrite(req *http.Request) *http.Request {
+	table, ps := r.rewrites.retrieve(r.requestPath(req), "", false, &r.paramsPool, r.maxParams)
+	if table == nil {
+		return req
+	}
+
+	p := Params{names: table.names}
+	if ps != nil {
+		p.values = ps.values
+	}
+	rewritten := r.rewriteTargets[table].expand(p, false)
+	r.putParams(ps)
+
+	ctx := context.WithValue(req.Context(), OriginalPathKey, req.URL.Path)
+	return withPath(req.WithContext(ctx), rewritten, r.UseRawPath)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ルートを登録するときに追加の設定を渡す
+type RouteOption func(*routeConfig)
+
+type routeConfig struct {
+	matchers      []matcher
+	produces      string
+	version       int
+	trailingSlash TrailingSlashPolicy
+	implicitHEAD  bool // GETのハンドルをHEADにも登録する
+}
+
+func newRouteConfig(opts []RouteOption) *routeConfig {
+	c := &routeConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// 415はほかの条件に一致した場合にだけ返したいので、Content-Typeの条件は最後に調べる
+	slices.SortStableFunc(c.matchers, func(a, b matcher) int {
+		return a.status - b.status
+	})
+	return c
+}
+
+func (c *routeConfig) candidate(handle Handle) candidate {
+	return candidate{
+		handle:   handle,
+		matchers: c.matchers,
+		produces: c.produces,
+		version:  c.version,
+	}
+}
+
+// 一致しなかった場合、ほかに一致する候補がなければstatusを返す
+type matcher struct {
+	match  func(*http.Request) bool
+	status int
+}
+
+// ヘッダーの値がvalueと等しい場合に一致する
+func MatchHeader(key, value string) RouteOption {
+	key = http.CanonicalHeaderKey(key)
+	return MatchFunc(func(req *http.Request) bool {
+		for _, v := range req.Header[key] {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ヘッダーの値が正規表現patternに一致する場合に一致する
+func MatchHeaderRegexp(key, pattern string) RouteOption {
+	key = http.CanonicalHeaderKey(key)
+	re := regexp.MustCompile(pattern)
+	return MatchFunc(func(req *http.Request) bool {
+		for _, v := range req.Header[key] {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// クエリパラメータkeyがある場合に一致する
+func MatchQuery(key string) RouteOption {
+	return MatchFunc(func(req *http.Request) bool {
+		return hasQuery(req.URL.RawQuery, key)
+	})
+}
+
+// Content-TypeのメディアタイプがmediaTypesのいずれかの場合に一致する
+// どの候補にも一致しなかった場合は415を返す
+func MatchContentType(mediaTypes ...string) RouteOption {
+	return func(c *routeConfig) {
+		c.matchers = append(c.matchers, matcher{
+			match: func(req *http.Request) bool {
+				mediaType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+				mediaType = strings.TrimSpace(mediaType)
+				for _, t := range mediaTypes {
+					if strings.EqualFold(mediaType, t) {
+						return true
+					}
+				}
+				return false
+			},
+			status: http.StatusUnsupportedMediaType,
+		})
+	}
+}
+
+// matchがtrueを返す場合に一致する
+func MatchFunc(match func(*http.Request) bool) RouteOption {
+	return func(c *routeConfig) {
+		c.matchers = append(c.matchers, matcher{
+			match:  match,
+			status: http.StatusNotFound,
+		})
+	}
+}
+
+// url.ParseQueryと違ってmapを作らずに、キーがあるかだけを調べる
+func hasQuery(query, key string) bool {
+	for query != "" {
+		var part string
+		part, query, _ = strings.Cut(query, "&")
+		k, _, _ := strings.Cut(part, "=")
+		if strings.ContainsAny(k, "%+") {
+			if unescaped, err := url.QueryUnescape(k); err == nil {
+				k = unescaped
+			}
+		}
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -7,44 +7,124 @@ package zerorouter
 import (
 	"context"
 	"net/http"
-	"path"
+	"net/url"
 	"slices"
 	"strings"
 	"sync"
 )
 
-func (r *Router) GET(path string, handle Handle) {
-	r.Handle(http.MethodGet, path, handle)
+func (r *Router) GET(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodGet, path, handle, opts...)
 }
 
-func (r *Router) HEAD(path string, handle Handle) {
-	r.Handle(http.MethodHead, path, handle)
+func (r *Router) HEAD(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodHead, path, handle, opts...)
 }
 
-func (r *Router) OPTIONS(path string, handle Handle) {
-	r.Handle(http.MethodOptions, path, handle)
+func (r *Router) OPTIONS(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodOptions, path, handle, opts...)
 }
 
-func (r *Router) POST(path string, handle Handle) {
-	r.Handle(http.MethodPost, path, handle)
+func (r *Router) POST(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodPost, path, handle, opts...)
 }
 
-func (r *Router) PUT(path string, handle Handle) {
-	r.Handle(http.MethodPut, path, handle)
+func (r *Router) PUT(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodPut, path, handle, opts...)
 }
 
-func (r *Router) PATCH(path string, handle Handle) {
-	r.Handle(http.MethodPatch, path, handle)
+func (r *Router) PATCH(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodPatch, path, handle, opts...)
 }
 
-func (r *Router) DELETE(path string, handle Handle) {
-	r.Handle(http.MethodDelete, path, handle)
+func (r *Router) DELETE(path string, handle Handle, opts ...RouteOption) {
+	r.Handle(http.MethodDelete, path, handle, opts...)
 }
 
-func (r *Router) Handle(method, path string, handle Handle) {
-	if method == "" {
-		panic("method must not be empty")
+func (r *Router) Handle(method, path string, handle Handle, opts ...RouteOption) {
+	r.HandleMethods([]string{method}, path, handle, opts...)
+}
+
+func (r *Router) HandleMethods(methods []string, path string, handle Handle, opts ...RouteOption) {
+	if len(methods) == 0 {
+		panic("methods must not be empty")
+	}
+	for _, method := range methods {
+		if method == "" {
+			panic("method must not be empty")
+		}
+	}
+
+	config := newRouteConfig(opts)
+	handle, names := r.prepareHandle(path, handle)
+
+	table := r.addRoute(path)
+	table.setParamNames(names)
+	r.versioned = r.versioned || config.version != 0
+	// 明示的に登録したHEADがあればそちらを使う
+	if config.implicitHEAD && slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) && table.candidates(http.MethodHead) == nil {
+		methods = append(slices.Clip(methods), http.MethodHead)
+	}
+	methodAdded := false
+	for _, method := range methods {
+		table.add(method, config.candidate(handle))
+		if !slices.Contains(r.methods, method) {
+			r.methods = append(r.methods, method)
+			methodAdded = true
+		}
+	}
+	r.updateMaxParams(table)
+
+	r.addTrailingSlashRoute(methods, path, names, config.candidate(handle), config.trailingSlash)
+	if methodAdded {
+		r.allowAllOnce = sync.Once{}
+	}
+}
+
+// メソッドを問わずマッチするルートを登録する
+// 同じパスにメソッドごとのルートがあれば、そちらが優先される
+func (r *Router) Any(path string, handle Handle, opts ...RouteOption) {
+	config := newRouteConfig(opts)
+	handle, names := r.prepareHandle(path, handle)
+
+	table := r.addRoute(path)
+	table.setParamNames(names)
+	r.versioned = r.versioned || config.version != 0
+	table.addAny(config.candidate(handle))
+	r.updateMaxParams(table)
+
+	r.addTrailingSlashRoute(nil, path, names, config.candidate(handle), config.trailingSlash)
+	if !r.hasAny {
+		r.hasAny = true
+		r.allowAllOnce = sync.Once{}
+	}
+}
+
+func (r *Router) addRoute(path string) *methodTable {
+	if r.frozen != nil {
+		panic("cannot add route '" + path + "' to a frozen router")
 	}
+	if r.tree == nil {
+		r.tree = new(node)
+	}
+	if r.cache != nil {
+		r.cache.clear()
+	}
+
+	table := r.tree.addRoute(path)
+
+	// ワイルドカードを含まないパスは、木をたどらずに引けるようにmapにも登録する
+	// 木の分割でノードが変わってもmethodTableは同じものが引き継がれる
+	if _, i, _ := findWildcard(path); i < 0 {
+		if r.staticTables == nil {
+			r.staticTables = make(map[string]*methodTable)
+		}
+		r.staticTables[path] = table
+	}
+	return table
+}
+
+func (r *Router) prepareHandle(path string, handle Handle) (Handle, []string) {
 	if len(path) < 1 || path[0] != '/' {
 		panic("path must begin with '/' in path '" + path + "'")
 	}
@@ -52,45 +132,62 @@ func (r *Router) Handle(method, path string, handle Handle) {
 		panic("handle must not be nil")
 	}
 
-	varsCount := 0
+	names := append(paramNames(path), r.hostNames...)
 	if r.SaveMatchedRoutePath {
-		varsCount++
+		names = append(names, MatchedRoutePathParam)
 		handle = r.saveMatchedRoutePath(path, handle)
 	}
+	return handle, names
+}
 
-	if r.trees == nil {
-		r.trees = make(map[string]*node)
-	}
-
-	root := r.trees[method]
-	if root == nil {
-		root = new(node)
-		r.trees[method] = root
-	}
-
-	root.addRoute(path, handle)
-
-	if paramsCount := countParams(path); paramsCount+varsCount > r.maxParams {
-		r.maxParams = paramsCount + varsCount
+// プールのバッファは登録済みのルートのうち最も多いパラメータ数に合わせる
+// 数が増えた場合、それより前に作られた小さいバッファは取り出したときに作り直す
+func (r *Router) updateMaxParams(table *methodTable) {
+	if len(table.names) > r.maxParams {
+		r.maxParams = len(table.names)
 	}
 
 	if r.paramsPool.New == nil && r.maxParams > 0 {
 		r.paramsPool.New = func() interface{} {
-			ps := make(Params, 0, r.maxParams)
-			return &ps
+			return &Params{values: make([]string, 0, r.maxParams)}
 		}
 	}
 }
 
 func (ps Params) ByName(name string) string {
-	for _, p := range ps {
-		if p.Key == name {
-			return p.Value
+	for i, n := range ps.names {
+		if n == name && i < len(ps.values) {
+			return ps.values[i]
 		}
 	}
 	return ""
 }
 
+// パターンの中でi番目に現れるパラメータの値を返す
+// 名前を比較しないので、パラメータの多いルートではByNameより速い
+func (ps Params) ByIndex(i int) string {
+	if i < 0 || i >= len(ps.values) {
+		return ""
+	}
+	return ps.values[i]
+}
+
+func (ps Params) Len() int {
+	return len(ps.values)
+}
+
+// 値はハンドルから戻るとプールに返されて再利用されるので、
+// ハンドルの外で使い続ける場合はCloneしたものを使う
+func (ps Params) Clone() Params {
+	if ps.values == nil {
+		return Params{names: ps.names}
+	}
+	return Params{
+		names:  ps.names,
+		values: slices.Clone(ps.values),
+	}
+}
+
 type paramsKey struct{}
 
 var ParamsKey = paramsKey{}
@@ -100,75 +197,66 @@ func ParamsFromContext(ctx context.Context) Params {
 	return p
 }
 
-func (r *Router) Handler(method, path string, handler http.Handler) {
-	r.Handle(method, path,
-		func(w http.ResponseWriter, req *http.Request, p Params) {
-			if len(p) > 0 {
-				ctx := req.Context()
-				ctx = context.WithValue(ctx, ParamsKey, p)
-				req = req.WithContext(ctx)
-			}
-			handler.ServeHTTP(w, req)
-		},
-	)
-}
-
-func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc) {
-	r.Handler(method, path, handler)
+func (r *Router) Handler(method, path string, handler http.Handler, opts ...RouteOption) {
+	r.Handle(method, path, handlerHandle(handler), opts...)
 }
 
-func (r *Router) allowed(path string) string {
-	allowed := make([]string, 0)
-
-	if path == "*" {
-		for method := range r.trees {
-			if method == http.MethodOptions {
-				continue
-			}
-			allowed = append(allowed, method)
-		}
-	} else {
-		for method := range r.trees {
-			if method == http.MethodOptions {
-				continue
-			}
-			handle := r.trees[method].retrieve_noparam(path)
-			if handle != nil {
-				allowed = append(allowed, method)
-			}
+// パラメータをcontextに入れてhttp.Handlerを呼ぶHandleを作る
+func handlerHandle(handler http.Handler) Handle {
+	return func(w http.ResponseWriter, req *http.Request, p Params) {
+		if p.Len() > 0 {
+			ctx := req.Context()
+			ctx = context.WithValue(ctx, ParamsKey, p.Clone())
+			req = req.WithContext(ctx)
 		}
+		handler.ServeHTTP(w, req)
 	}
+}
 
-	if len(allowed) > 0 {
-		allowed = append(allowed, http.MethodOptions)
-		slices.Sort(allowed)
-		return strings.Join(allowed, ", ")
-	}
-
-	return ""
+func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc, opts ...RouteOption) {
+	r.Handler(method, path, handler, opts...)
 }
 
-func (r *Router) getParams() *Params {
-	ps, _ := r.paramsPool.Get().(*Params)
-	*ps = (*ps)[0:0]
+func paramsFromPool(pool *sync.Pool, size int) *Params {
+	ps, _ := pool.Get().(*Params)
+	if cap(ps.values) < size {
+		ps.values = make([]string, 0, size)
+	}
+	ps.names = nil
+	ps.values = ps.values[0:0]
 	return ps
 }
 
+// PoisonParamsが有効な場合は、ハンドルの外に持ち出された値を見つけられるように
+// プールに返すバッファの中身を書き潰しておく
+const poisonedParam = "zerorouter: params used after the handle returned"
+
 func (r *Router) putParams(ps *Params) {
 	if ps != nil {
+		if r.PoisonParams {
+			values := ps.values[:cap(ps.values)]
+			for i := range values {
+				values[i] = poisonedParam
+			}
+		}
 		r.paramsPool.Put(ps)
 	}
 }
 
-func countParams(path string) int {
-	var n int
-	for i := range []byte(path) {
+func paramNames(path string) []string {
+	var names []string
+	for i := 0; i < len(path); i++ {
 		switch path[i] {
 		case ':', '*':
-			n++
+			end := i + 1
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+			names = append(names, path[i+1:end])
+			i = end
 		}
 	}
-	return n
+	return names
 }
 
 func (r *Router) recv(w http.ResponseWriter, req *http.Request) {
@@ -178,12 +266,36 @@ func (r *Router) recv(w http.ResponseWriter, req *http.Request) {
 }
 
 type Router struct {
-	trees                  map[string]*node
+	tree                   *node
+	frozen                 *frozenTree
+	methods                []string
+	hasAny                 bool
+	staticTables           map[string]*methodTable
+	cache                  *lookupCache
+	staticHosts            map[string]*Router
+	hosts                  *node
+	hostRouters            map[*methodTable]*Router
+	hostNames              []string
+	allowAll               allowHeader
+	allowAllOnce           sync.Once
 	PanicHandler           func(http.ResponseWriter, *http.Request, interface{})
 	HandleOPTIONS          bool
 	HandleMethodNotAllowed bool
+	HandleHEAD             bool
 	SaveMatchedRoutePath   bool
 	RedirectFixedPath      bool
+	ServeCleanedPath       bool
+	PathCleaning           CleanOptions
+	UseRawPath             bool
+	UnescapePathValues     bool
+	StaticFastPath         bool
+	PoisonParams           bool
+	Versioning             Versioning
+	versioned              bool
+	deprecations           map[int]deprecation
+	rewrites               *node
+	rewriteTargets         map[*methodTable]pathTemplate
+	patterns               []muxPattern
 	paramsPool             sync.Pool
 	maxParams              int
 }
@@ -192,68 +304,269 @@ func New() *Router {
 	return &Router{}
 }
 
+// 登録済みのルートを配列に詰めた木に変換し、以降の探索はそちらで行う
+// Freezeの後はルートを追加できない
+func (r *Router) Freeze() {
+	if r.frozen != nil {
+		return
+	}
+	if r.tree == nil {
+		r.tree = new(node)
+	}
+	r.frozen = freezeTree(r.tree)
+	r.tree = nil
+	for _, sub := range r.staticHosts {
+		sub.Freeze()
+	}
+	for _, sub := range r.hostRouters {
+		sub.Freeze()
+	}
+}
+
+// 最近の探索結果を最大size件まで覚えておき、同じメソッドとパスでは木をたどらずに済ませる
+// パラメータを含むルートに少数の具体的なURLが集中する場合に効果がある
+// ルートを追加するとキャッシュは空になる。sizeが0以下の場合はキャッシュを使わない
+// 256バイトより長いパスは覚えないので、キャッシュが使うメモリはsizeに比例する量に収まる
+// Hostで作ったRouterもそれぞれ同じ大きさのキャッシュを持つ
+func (r *Router) UseLookupCache(size int) {
+	if size <= 0 {
+		r.cache = nil
+	} else {
+		r.cache = newLookupCache(size)
+	}
+	for _, sub := range r.staticHosts {
+		sub.UseLookupCache(size)
+	}
+	for _, sub := range r.hostRouters {
+		sub.UseLookupCache(size)
+	}
+}
+
+func (r *Router) retrieve(path, method string) (*methodTable, *Params) {
+	if r.cache == nil {
+		return r.retrieveTree(path, method)
+	}
+	if table, ps, ok := r.cache.get(method, path, &r.paramsPool, r.maxParams); ok {
+		return table, ps
+	}
+	table, ps := r.retrieveTree(path, method)
+	if table != nil {
+		r.cache.add(method, path, table, ps)
+	}
+	return table, ps
+}
+
+func (r *Router) retrieveTree(path, method string) (*methodTable, *Params) {
+	if r.frozen != nil {
+		return r.frozen.retrieve(path, method, r.HandleHEAD, &r.paramsPool, r.maxParams)
+	}
+	if r.tree != nil {
+		return r.tree.retrieve(path, method, r.HandleHEAD, &r.paramsPool, r.maxParams)
+	}
+	return nil, nil
+}
+
+// serveと同じ順にパスを書き換えて探し、reqのメソッドのハンドルがあるかを返す
+func (r *Router) canServe(req *http.Request) bool {
+	if r.rewrites != nil {
+		req = r.rewrite(req)
+	}
+	lookupPath := r.requestPath(req)
+	if r.versioned {
+		_, lookupPath = r.requestedVersion(req, lookupPath)
+	}
+	table := r.retrieve_noparam(lookupPath, req.Method, r.HandleHEAD)
+	return table != nil && table.has(req.Method, r.HandleHEAD)
+}
+
+func (r *Router) catchAllFallback(path string) *methodTable {
+	if r.frozen != nil {
+		return r.frozen.catchAllFallback(path)
+	}
+	if r.tree != nil {
+		return r.tree.catchAllFallback(path)
+	}
+	return nil
+}
+
+func (r *Router) retrieve_noparam(path, method string, handleHEAD bool) *methodTable {
+	if r.frozen != nil {
+		return r.frozen.retrieve_noparam(path, method, handleHEAD)
+	}
+	if r.tree != nil {
+		return r.tree.retrieve_noparam(path, method, handleHEAD)
+	}
+	return nil
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if r.PanicHandler != nil {
 		defer r.recv(w, req)
 	}
-	urlPath := req.URL.Path
-	if root := r.trees[req.Method]; root != nil {
-		if handle, ps := root.retrieve(urlPath, r.getParams); handle != nil {
-			if ps != nil {
-				handle(w, req, *ps)
-				r.putParams(ps)
+	if (r.staticHosts != nil || r.hosts != nil) && r.serveHost(w, req) {
+		return
+	}
+	r.serve(w, req, nil)
+}
+
+// UseRawPathが有効な場合は、"%2F"を"/"に戻す前のパスでルートを探す
+func (r *Router) requestPath(req *http.Request) string {
+	if r.UseRawPath {
+		return req.URL.EscapedPath()
+	}
+	return req.URL.Path
+}
+
+// パラメータの値のエスケープを戻す。不正なエスケープを含む値はそのまま残す
+func unescapeValues(values []string) {
+	for i, v := range values {
+		if strings.IndexByte(v, '%') < 0 {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(v); err == nil {
+			values[i] = unescaped
+		}
+	}
+}
+
+// hostValuesはHostのパターンで捕まえた値で、パスのパラメータの後に付け足す
+func (r *Router) serve(w http.ResponseWriter, req *http.Request, hostValues []string) {
+	orig := req
+	if r.rewrites != nil {
+		req = r.rewrite(req)
+	}
+	urlPath := r.requestPath(req)
+	lookupPath := urlPath
+	version := 0
+	if r.versioned {
+		version, lookupPath = r.requestedVersion(req, urlPath)
+	}
+
+	var table *methodTable
+	var ps *Params
+	if r.StaticFastPath {
+		if static := r.staticTables[lookupPath]; static != nil && static.has(req.Method, r.HandleHEAD) {
+			table = static
+		}
+	}
+	if table == nil {
+		table, ps = r.retrieve(lookupPath, req.Method)
+	}
+	if table != nil {
+		c, headFromGet, status := table.lookup(req, version, r.HandleHEAD)
+		if c != nil {
+			handle := c.handle
+			if c.vary {
+				w.Header().Add("Vary", "Accept")
+			}
+			if c.versioned {
+				r.setVersionHeaders(w, c)
+			}
+			if r.UseRawPath && r.UnescapePathValues && ps != nil {
+				unescapeValues(ps.values)
+			}
+			if len(hostValues) > 0 {
+				if ps == nil {
+					ps = paramsFromPool(&r.paramsPool, r.maxParams)
+				}
+				ps.values = append(ps.values, hostValues...)
+			}
+			if headFromGet {
+				hw := getHeadResponseWriter(w)
+				r.serveHandle(hw, req, handle, table.names, ps)
+				hw.finish()
+				putHeadResponseWriter(hw)
 			} else {
-				handle(w, req, nil)
+				r.serveHandle(w, req, handle, table.names, ps)
 			}
 			return
-		} else if urlPath != "/" {
-			code := http.StatusMovedPermanently
-			if req.Method != http.MethodGet {
-				code = http.StatusPermanentRedirect
+		}
+		r.putParams(ps)
+
+		// メソッドにはハンドルがあるが、どの条件にも一致しなかった
+		if status == http.StatusNotFound {
+			http.NotFound(w, req)
+			return
+		} else if status != 0 {
+			if status == http.StatusNotAcceptable {
+				w.Header().Add("Vary", "Accept")
 			}
-			if r.RedirectFixedPath {
-				fixedPath := path.Clean(urlPath)
-				if handle := root.retrieve_noparam(fixedPath); handle != nil {
-					req.URL.Path = fixedPath
-					http.Redirect(w, req, req.URL.String(), code)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+
+	// 書き換える前のパスを正規化し、書き換えとバージョンの指定を適用してから探す
+	if origPath := r.requestPath(orig); origPath != "/" && (r.RedirectFixedPath || r.ServeCleanedPath) {
+		fixedPath := CleanPath(origPath, r.PathCleaning)
+		if fixedPath != origPath {
+			if fixedReq := withPath(orig, fixedPath, r.UseRawPath); r.canServe(fixedReq) {
+				if r.ServeCleanedPath {
+					// 正規化したパスはもう一度正規化しても変わらないので、ここには戻ってこない
+					r.serve(w, fixedReq, hostValues)
 					return
 				}
+				code := http.StatusMovedPermanently
+				if req.Method != http.MethodGet {
+					code = http.StatusPermanentRedirect
+				}
+				setURLPath(orig.URL, fixedPath, r.UseRawPath)
+				http.Redirect(w, orig, orig.URL.String(), code)
+				return
 			}
 		}
 	}
+
+	var allow []string
+	if table != nil {
+		// メソッドがなければcatchAllに戻って処理するので、そのメソッドも許可されている
+		if fallback := r.catchAllFallback(lookupPath); fallback != nil && fallback != table {
+			allow = table.allowWithFallback(fallback).get(r.HandleHEAD)
+		} else {
+			allow = table.allowHeader().get(r.HandleHEAD)
+		}
+	} else if urlPath == "*" {
+		r.allowAllOnce.Do(func() {
+			r.allowAll = newAllowHeader(r.methods, r.hasAny)
+		})
+		allow = r.allowAll.get(r.HandleHEAD)
+	}
 	if req.Method == http.MethodOptions && r.HandleOPTIONS {
-		if allow := r.allowed(urlPath); allow != "" {
-			w.Header().Set("Allow", allow)
+		if allow != nil {
+			w.Header()["Allow"] = allow
 			return
 		}
 	} else if r.HandleMethodNotAllowed {
-		if allow := r.allowed(urlPath); allow != "" {
-			w.Header().Set("Allow", allow)
-			http.Error(w,
-				http.StatusText(http.StatusMethodNotAllowed),
-				http.StatusMethodNotAllowed,
-			)
+		if allow != nil {
+			w.Header()["Allow"] = allow
+			methodNotAllowed(w)
 			return
 		}
 	}
 	http.NotFound(w, req)
 }
 
+func (r *Router) serveHandle(w http.ResponseWriter, req *http.Request, handle Handle, names []string, ps *Params) {
+	if ps != nil {
+		ps.names = names
+		handle(w, req, *ps)
+		r.putParams(ps)
+	} else {
+		handle(w, req, Params{names: names})
+	}
+}
+
 var MatchedRoutePathParam = "$matchedRoutePath"
 
 func (r *Router) saveMatchedRoutePath(path string, handle Handle) Handle {
+	// パラメータのないルートでは、値がパス1つだけのスライスを使い回す
+	matched := []string{path}
 	return func(w http.ResponseWriter, req *http.Request, ps Params) {
-		if ps == nil {
-			psp := r.getParams()
-			ps = (*psp)[0:1]
-			ps[0] = Param{Key: MatchedRoutePathParam, Value: path}
-			handle(w, req, ps)
-			r.putParams(psp)
+		if ps.values == nil {
+			ps.values = matched
 		} else {
-			i := len(ps)
-			ps = ps[:i+1]
-			ps[i] = Param{Key: MatchedRoutePathParam, Value: path}
-			handle(w, req, ps)
+			ps.values = append(ps.values, path)
 		}
+		handle(w, req, ps)
 	}
 }
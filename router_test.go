@@ -0,0 +1,1458 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestServeFilesPrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":       {Data: []byte("console.log(1)")},
+		"app.js.br":    {Data: []byte("brotli")},
+		"app.js.gz":    {Data: []byte("gzip")},
+		"style.css":    {Data: []byte("body{}")},
+		"style.css.gz": {Data: []byte("gzip-css")},
+		"plain.txt":    {Data: []byte("plain")},
+		"noext":        {Data: []byte("<html><body>hi</body></html>")},
+		"noext.gz":     {Data: []byte("gzip-html")},
+	}
+
+	router := New()
+	router.ServeFiles("/static/*filepath", http.FS(fsys))
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		expectedBody   string
+		expectedEnc    string
+	}{
+		{"/static/app.js", "gzip, br", "brotli", "br"},
+		{"/static/app.js", "gzip", "gzip", "gzip"},
+		{"/static/app.js", "br;q=0.5, gzip;q=0.8", "gzip", "gzip"},
+		{"/static/app.js", "br;q=0, gzip;q=0", "console.log(1)", ""},
+		{"/static/app.js", "", "console.log(1)", ""},
+		{"/static/app.js", "*", "brotli", "br"},
+		{"/static/style.css", "br, gzip", "gzip-css", "gzip"},
+		{"/static/plain.txt", "br, gzip", "plain", ""},
+		{"/static/noext", "gzip", "gzip-html", "gzip"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s (Accept-Encoding: %s) returned status %d; want %d", test.path, test.acceptEncoding, w.Code, http.StatusOK)
+			continue
+		}
+		if body := w.Body.String(); body != test.expectedBody {
+			t.Errorf("GET %s (Accept-Encoding: %s) returned body %q; want %q", test.path, test.acceptEncoding, body, test.expectedBody)
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != test.expectedEnc {
+			t.Errorf("GET %s (Accept-Encoding: %s) returned Content-Encoding %q; want %q", test.path, test.acceptEncoding, enc, test.expectedEnc)
+		}
+		// 拡張子のあるファイルの種類は/etc/mime.typesで変わることがあるので、同じ方法で求める
+		expectedCtype := "text/html; charset=utf-8"
+		if ext := path.Ext(test.path); ext != "" {
+			expectedCtype = mime.TypeByExtension(ext)
+		}
+		if ctype := w.Header().Get("Content-Type"); ctype != expectedCtype {
+			t.Errorf("GET %s (Accept-Encoding: %s) returned Content-Type %q; want %q", test.path, test.acceptEncoding, ctype, expectedCtype)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("GET %s (Accept-Encoding: %s) returned Vary %q", test.path, test.acceptEncoding, vary)
+		}
+	}
+}
+
+func TestServeSPA(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<html>spa</html>")},
+		"assets/app.js":    {Data: []byte("console.log(1)")},
+		"assets/app.js.gz": {Data: []byte("gzip")},
+	}
+
+	router := New()
+	router.HandleMethodNotAllowed = true
+	router.GET("/api/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("user " + ps.ByName("id")))
+	})
+	router.POST("/api/users", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("created"))
+	})
+	router.ServeSPA("/", fsys, "index.html")
+
+	tests := []struct {
+		method         string
+		path           string
+		acceptEncoding string
+		expectedCode   int
+		expectedBody   string
+	}{
+		{http.MethodGet, "/", "", http.StatusOK, "<html>spa</html>"},
+		{http.MethodGet, "/dashboard/settings", "", http.StatusOK, "<html>spa</html>"},
+		{http.MethodGet, "/assets", "", http.StatusOK, "<html>spa</html>"},
+		{http.MethodGet, "/assets/app.js", "", http.StatusOK, "console.log(1)"},
+		{http.MethodGet, "/assets/app.js", "gzip", http.StatusOK, "gzip"},
+		{http.MethodGet, "/api/users/7", "", http.StatusOK, "user 7"},
+		{http.MethodGet, "/api/users", "", http.StatusOK, "<html>spa</html>"},
+		{http.MethodPost, "/api/users", "", http.StatusOK, "created"},
+		{http.MethodPost, "/dashboard", "", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.path, w.Code, test.expectedCode)
+		}
+		if body := w.Body.String(); body != test.expectedBody {
+			t.Errorf("%s %s returned body %q; want %q", test.method, test.path, body, test.expectedBody)
+		}
+	}
+
+	// GETはcatchAllで処理されるので、Allowに含まれる
+	router.HandleOPTIONS = true
+	for _, frozen := range []bool{false, true} {
+		if frozen {
+			router.Freeze()
+		}
+		for _, method := range []string{http.MethodDelete, http.MethodOptions} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, "/api/users", nil))
+			if allow := w.Header().Get("Allow"); allow != "GET, OPTIONS, POST" {
+				t.Errorf("%s /api/users returned Allow %q (frozen=%v); want %q", method, allow, frozen, "GET, OPTIONS, POST")
+			}
+		}
+	}
+
+	// catchAllにメソッドを追加すると、合わせたAllowも作り直される
+	spa := New()
+	spa.HandleOPTIONS = true
+	spa.GET("/*filepath", func(http.ResponseWriter, *http.Request, Params) {})
+	spa.POST("/api/users", func(http.ResponseWriter, *http.Request, Params) {})
+	for _, expected := range []string{"GET, OPTIONS, POST", "GET, OPTIONS, POST, PUT"} {
+		w := httptest.NewRecorder()
+		spa.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/api/users", nil))
+		if allow := w.Header().Get("Allow"); allow != expected {
+			t.Errorf("OPTIONS /api/users returned Allow %q; want %q", allow, expected)
+		}
+		spa.PUT("/*filepath", func(http.ResponseWriter, *http.Request, Params) {})
+	}
+
+	router = New()
+	router.ServeSPA("/app", fsys, "index.html")
+	for _, p := range []string{"/app", "/app/", "/app/dashboard"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusOK || w.Body.String() != "<html>spa</html>" {
+			t.Errorf("GET %s returned status %d and body %q; want the index", p, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/assets/app.js", nil))
+	if body := w.Body.String(); body != "console.log(1)" {
+		t.Errorf("GET /app/assets/app.js returned body %q; want %q", body, "console.log(1)")
+	}
+}
+
+func TestHandleHEAD(t *testing.T) {
+	router := New()
+	router.HandleHEAD = true
+	router.HandleOPTIONS = true
+	router.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Header().Set("X-User", ps.ByName("id"))
+		w.Write([]byte("user " + ps.ByName("id")))
+	})
+	router.GET("/created", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.GET("/explicit", fakeHandler("get"))
+	router.HEAD("/explicit", fakeHandler("head"))
+	router.GET("/stream", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("chunk"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush in GET handle served as HEAD returned %v", err)
+		}
+		w.Write([]byte("chunk"))
+	})
+
+	req := httptest.NewRequest(http.MethodHead, "/users/42", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("HEAD /users/42 returned status %d; want %d", w.Code, http.StatusOK)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD /users/42 returned body %q; want empty", w.Body.String())
+	}
+	if got := w.Header().Get("X-User"); got != "42" {
+		t.Errorf("HEAD /users/42 returned X-User %q; want %q", got, "42")
+	}
+	if got := w.Header().Get("Content-Length"); got != "7" {
+		t.Errorf("HEAD /users/42 returned Content-Length %q; want %q", got, "7")
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/created", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("HEAD /created returned status %d; want %d", w.Code, http.StatusCreated)
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/stream", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "10" {
+		t.Errorf("HEAD /stream returned body %q and Content-Length %q; want empty and %q", w.Body.String(), w.Header().Get("Content-Length"), "10")
+	}
+
+	req = httptest.NewRequest(http.MethodHead, "/explicit", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if fakeHandlerValue != "head" {
+		t.Errorf("HEAD /explicit called %q handler; want %q", fakeHandlerValue, "head")
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/users/42", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS /users/42 returned Allow %q; want %q", allow, "GET, HEAD, OPTIONS")
+	}
+
+	router.GET("/mixed", fakeHandler("get"))
+	router.Any("/mixed", fakeHandler("any"))
+	for _, handleHEAD := range []bool{true, false} {
+		router.HandleHEAD = handleHEAD
+		fakeHandlerValue = ""
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "/mixed", nil))
+		if fakeHandlerValue != "any" {
+			t.Errorf("HEAD /mixed with HandleHEAD %v called %q handler; want %q", handleHEAD, fakeHandlerValue, "any")
+		}
+	}
+
+	router.HandleHEAD = false
+	req = httptest.NewRequest(http.MethodHead, "/users/42", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HEAD /users/42 without HandleHEAD returned status %d; want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAnyAndHandleMethods(t *testing.T) {
+	router := New()
+	router.HandleOPTIONS = true
+	router.HandleMethodNotAllowed = true
+	router.SaveMatchedRoutePath = true
+	router.Any("/webhook/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("any " + ps.ByName("id") + " " + ps.ByName(MatchedRoutePathParam)))
+	})
+	router.GET("/webhook/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("get " + ps.ByName("id")))
+	})
+	router.HandleMethods([]string{http.MethodPut, http.MethodPatch}, "/items/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte(req.Method + " " + ps.ByName("id") + " " + ps.ByName(MatchedRoutePathParam)))
+	})
+
+	tests := []struct {
+		method        string
+		path          string
+		expectedCode  int
+		expectedBody  string
+		expectedAllow string
+	}{
+		{http.MethodGet, "/webhook/1", http.StatusOK, "get 1", ""},
+		{http.MethodPost, "/webhook/1", http.StatusOK, "any 1 /webhook/:id", ""},
+		{"PROPFIND", "/webhook/1", http.StatusOK, "any 1 /webhook/:id", ""},
+		{http.MethodPut, "/items/2", http.StatusOK, "PUT 2 /items/:id", ""},
+		{http.MethodPatch, "/items/2", http.StatusOK, "PATCH 2 /items/:id", ""},
+		{http.MethodDelete, "/items/2", http.StatusMethodNotAllowed, "Method Not Allowed\n", "OPTIONS, PATCH, PUT"},
+		{http.MethodOptions, "/items/2", http.StatusOK, "", "OPTIONS, PATCH, PUT"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.path, w.Code, test.expectedCode)
+		}
+		if body := w.Body.String(); body != test.expectedBody {
+			t.Errorf("%s %s returned body %q; want %q", test.method, test.path, body, test.expectedBody)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.expectedAllow {
+			t.Errorf("%s %s returned Allow %q; want %q", test.method, test.path, allow, test.expectedAllow)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "*", nil))
+	if allow := w.Header().Get("Allow"); allow != "CONNECT, DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT, TRACE" {
+		t.Errorf("OPTIONS * returned Allow %q", allow)
+	}
+
+	// Allowの値はリクエストを処理した後に追加したメソッドも反映する
+	router.DELETE("/items/:id", func(http.ResponseWriter, *http.Request, Params) {})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/items/2", nil))
+	if allow := w.Header().Get("Allow"); allow != "DELETE, OPTIONS, PATCH, PUT" {
+		t.Errorf("OPTIONS /items/2 after adding DELETE returned Allow %q", allow)
+	}
+}
+
+func TestStaticFastPath(t *testing.T) {
+	router := New()
+	router.StaticFastPath = true
+	router.HandleHEAD = true
+	router.HandleMethodNotAllowed = true
+	router.GET("/health", fakeHandler("health"))
+	router.POST("/api/users", fakeHandler("create"))
+	router.GET("/api/users/:id", fakeHandler("user"))
+	router.GET("/app/*filepath", fakeHandler("spa"))
+	router.GET("/app/settings", fakeHandler("settings"))
+	router.PUT("/app/upload", fakeHandler("upload"))
+
+	if len(router.staticTables) != 4 {
+		t.Errorf("staticTables has %d entries; want 4", len(router.staticTables))
+	}
+
+	tests := []struct {
+		method        string
+		path          string
+		expectedCode  int
+		expectedValue string
+		expectedAllow string
+	}{
+		{http.MethodGet, "/health", http.StatusOK, "health", ""},
+		{http.MethodHead, "/health", http.StatusOK, "health", ""},
+		{http.MethodPost, "/api/users", http.StatusOK, "create", ""},
+		{http.MethodGet, "/api/users/1", http.StatusOK, "user", ""},
+		{http.MethodGet, "/app/settings", http.StatusOK, "settings", ""},
+		{http.MethodGet, "/app/upload", http.StatusOK, "spa", ""},
+		{http.MethodDelete, "/health", http.StatusMethodNotAllowed, "", "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/api/users", http.StatusMethodNotAllowed, "", "OPTIONS, POST"},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue = ""
+		req := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.path, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("%s %s called %q handler; want %q", test.method, test.path, fakeHandlerValue, test.expectedValue)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.expectedAllow {
+			t.Errorf("%s %s returned Allow %q; want %q", test.method, test.path, allow, test.expectedAllow)
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	router := New()
+	router.HandleOPTIONS = true
+	router.HandleMethodNotAllowed = true
+	router.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("user " + ps.ByName("id")))
+	})
+	router.POST("/users", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("created"))
+	})
+	router.GET("/files/*filepath", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("file " + ps.ByName("filepath")))
+	})
+	router.Freeze()
+
+	tests := []struct {
+		method        string
+		path          string
+		expectedCode  int
+		expectedBody  string
+		expectedAllow string
+	}{
+		{http.MethodGet, "/users/1", http.StatusOK, "user 1", ""},
+		{http.MethodPost, "/users", http.StatusOK, "created", ""},
+		{http.MethodGet, "/files/css/app.css", http.StatusOK, "file /css/app.css", ""},
+		{http.MethodGet, "/users", http.StatusMethodNotAllowed, "Method Not Allowed\n", "OPTIONS, POST"},
+		{http.MethodOptions, "/users/1", http.StatusOK, "", "GET, OPTIONS"},
+		{http.MethodGet, "/missing", http.StatusNotFound, "404 page not found\n", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.path, w.Code, test.expectedCode)
+		}
+		if body := w.Body.String(); body != test.expectedBody {
+			t.Errorf("%s %s returned body %q; want %q", test.method, test.path, body, test.expectedBody)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.expectedAllow {
+			t.Errorf("%s %s returned Allow %q; want %q", test.method, test.path, allow, test.expectedAllow)
+		}
+	}
+
+	if recv := catchPanic(func() {
+		router.GET("/late", fakeHandler("late"))
+	}); recv == nil {
+		t.Error("adding a route after Freeze did not panic")
+	}
+}
+
+func TestZeroAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	handle := func(http.ResponseWriter, *http.Request, Params) {}
+
+	newRouter := func(frozen, handleHEAD bool) *Router {
+		router := New()
+		router.HandleOPTIONS = true
+		router.HandleMethodNotAllowed = true
+		router.HandleHEAD = handleHEAD
+		router.GET("/health", handle)
+		router.GET("/users/:id", handle)
+		router.GET("/repos/:owner/:repo/issues/:number", handle)
+		router.GET("/static/*filepath", handle)
+		// ServeSPA("/")のように、ルートのcatchAllの隣に静的なルートがある
+		router.GET("/*filepath", handle)
+		router.POST("/api/users", handle)
+		if frozen {
+			router.Freeze()
+		}
+		return router
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"Static", http.MethodGet, "/health"},
+		{"Param", http.MethodGet, "/users/42"},
+		{"Params", http.MethodGet, "/repos/wolfmagnate/zerorouter/issues/1"},
+		{"CatchAll", http.MethodGet, "/static/js/app.js"},
+		{"OPTIONS", http.MethodOptions, "/users/42"},
+		{"MethodNotAllowed", http.MethodPost, "/users/42"},
+		{"OPTIONSWithFallback", http.MethodOptions, "/api/users"},
+		{"MethodNotAllowedWithFallback", http.MethodDelete, "/api/users"},
+		{"HEAD", http.MethodHead, "/health"},
+	}
+
+	for _, frozen := range []bool{false, true} {
+		for _, handleHEAD := range []bool{false, true} {
+			router := newRouter(frozen, handleHEAD)
+			for _, test := range tests {
+				req := httptest.NewRequest(test.method, test.path, nil)
+				w := newBenchResponseWriter()
+				allocs := testing.AllocsPerRun(100, func() {
+					router.ServeHTTP(w, req)
+				})
+				if allocs != 0 {
+					t.Errorf("%s (frozen: %v, HandleHEAD: %v) %s %s allocated %v times; want 0", test.name, frozen, handleHEAD, test.method, test.path, allocs)
+				}
+			}
+		}
+	}
+}
+
+func TestParamsPoolGrowth(t *testing.T) {
+	router := New()
+	router.SaveMatchedRoutePath = true
+
+	routes := []struct {
+		pattern      string
+		path         string
+		params       []string
+		expectedBody string
+	}{
+		{"/static", "/static", nil, "/static"},
+		{"/a/:p1", "/a/1", []string{"p1"}, "1 /a/:p1"},
+		{"/b/:p1/:p2", "/b/1/2", []string{"p1", "p2"}, "1 2 /b/:p1/:p2"},
+		{"/c/:p1/:p2/*p3", "/c/1/2/3", []string{"p1", "p2", "p3"}, "1 2 /3 /c/:p1/:p2/*p3"},
+		{"/d/:p1/:p2/:p3/:p4/:p5", "/d/1/2/3/4/5", []string{"p1", "p2", "p3", "p4", "p5"}, "1 2 3 4 5 /d/:p1/:p2/:p3/:p4/:p5"},
+	}
+
+	for i, route := range routes {
+		route := route
+		router.GET(route.pattern, func(w http.ResponseWriter, req *http.Request, ps Params) {
+			for _, name := range route.params {
+				w.Write([]byte(ps.ByName(name) + " "))
+			}
+			w.Write([]byte(ps.ByName(MatchedRoutePathParam)))
+		})
+		if router.maxParams != len(route.params)+1 {
+			t.Errorf("maxParams after registering %s = %d; want %d", route.pattern, router.maxParams, len(route.params)+1)
+		}
+
+		// 先に登録したルートに合わせて作られた小さいバッファがプールに残っている状態で探索する
+		for _, served := range routes[:i+1] {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, served.path, nil))
+			if body := w.Body.String(); body != served.expectedBody {
+				t.Errorf("GET %s returned body %q; want %q", served.path, body, served.expectedBody)
+			}
+		}
+	}
+}
+
+func TestParamsByIndex(t *testing.T) {
+	router := New()
+	router.SaveMatchedRoutePath = true
+
+	var got Params
+	handle := func(w http.ResponseWriter, req *http.Request, ps Params) {
+		got = ps
+	}
+	router.GET("/repos/:owner/:repo/*filepath", handle)
+	router.GET("/repos/:owner/:repo/issues/:number", handle)
+	router.GET("/health", handle)
+
+	tests := []struct {
+		path   string
+		names  []string
+		values []string
+	}{
+		{"/repos/a/b/issues/1", []string{"owner", "repo", "number", MatchedRoutePathParam}, []string{"a", "b", "1", "/repos/:owner/:repo/issues/:number"}},
+		{"/repos/a/b/issues/1/comments", []string{"owner", "repo", "filepath", MatchedRoutePathParam}, []string{"a", "b", "/issues/1/comments", "/repos/:owner/:repo/*filepath"}},
+		{"/health", []string{MatchedRoutePathParam}, []string{"/health"}},
+	}
+
+	for _, test := range tests {
+		got = Params{}
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		if got.Len() != len(test.values) {
+			t.Errorf("GET %s returned %d params; want %d", test.path, got.Len(), len(test.values))
+			continue
+		}
+		for i, value := range test.values {
+			if v := got.ByIndex(i); v != value {
+				t.Errorf("GET %s ByIndex(%d) = %q; want %q", test.path, i, v, value)
+			}
+			if v := got.ByName(test.names[i]); v != value {
+				t.Errorf("GET %s ByName(%s) = %q; want %q", test.path, test.names[i], v, value)
+			}
+		}
+		if v := got.ByIndex(len(test.values)); v != "" {
+			t.Errorf("GET %s ByIndex(%d) = %q; want empty", test.path, len(test.values), v)
+		}
+	}
+}
+
+func TestParamsOwnership(t *testing.T) {
+	router := New()
+	router.PoisonParams = true
+
+	var retained, cloned Params
+	router.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		retained = ps
+		cloned = ps.Clone()
+	})
+	var fromContext Params
+	router.Handler(http.MethodGet, "/items/:id", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fromContext = ParamsFromContext(req.Context())
+	}))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if v := retained.ByName("id"); v != poisonedParam {
+		t.Errorf("retained ByName(id) = %q; want poisoned value", v)
+	}
+	if v := cloned.ByName("id"); v != "1" {
+		t.Errorf("cloned ByName(id) = %q; want %q", v, "1")
+	}
+
+	first := cloned
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/2", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/3", nil))
+	if v := fromContext.ByName("id"); v != "2" {
+		t.Errorf("params from context ByName(id) = %q; want %q", v, "2")
+	}
+	if v := first.ByName("id"); v != "1" {
+		t.Errorf("cloned ByName(id) after another request = %q; want %q", v, "1")
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	router := New()
+	router.SaveMatchedRoutePath = true
+	router.UseLookupCache(64)
+	router.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("user " + ps.ByName("id") + " " + ps.ByName(MatchedRoutePathParam)))
+	})
+	router.GET("/files/*filepath", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("file " + ps.ByName("filepath")))
+	})
+
+	serve := func(path string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Body.String()
+	}
+
+	for i := 0; i < 3; i++ {
+		if body := serve("/users/me"); body != "user me /users/:id" {
+			t.Errorf("GET /users/me (%d) returned body %q", i, body)
+		}
+		if body := serve("/files/a/b.txt"); body != "file /a/b.txt" {
+			t.Errorf("GET /files/a/b.txt (%d) returned body %q", i, body)
+		}
+	}
+	if n := router.cache.len(); n != 2 {
+		t.Errorf("cache has %d entries; want 2", n)
+	}
+	long := "/files/" + strings.Repeat("a", maxCachedPathLen)
+	if body := serve(long); body != "file "+long[len("/files"):] {
+		t.Errorf("GET %s returned body %q", long, body)
+	}
+	if n := router.cache.len(); n != 2 {
+		t.Errorf("cache has %d entries after a long path; want 2", n)
+	}
+
+	// ルートを追加すると、以前の探索結果は使われない
+	router.GET("/files/a/b.txt", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("b.txt"))
+	})
+	if n := router.cache.len(); n != 0 {
+		t.Errorf("cache has %d entries after adding a route; want 0", n)
+	}
+	if body := serve("/files/a/b.txt"); body != "b.txt" {
+		t.Errorf("GET /files/a/b.txt after adding a route returned body %q; want %q", body, "b.txt")
+	}
+
+	for i := 0; i < 1000; i++ {
+		serve("/users/" + strconv.Itoa(i))
+	}
+	if n := router.cache.len(); n > 64 {
+		t.Errorf("cache has %d entries; want at most 64", n)
+	}
+
+	router = New()
+	router.UseLookupCache(64)
+	router.GET("/users/:id", func(http.ResponseWriter, *http.Request, Params) {})
+	req := httptest.NewRequest(http.MethodGet, "/users/999", nil)
+	w := newBenchResponseWriter()
+	if !raceEnabled {
+		if allocs := testing.AllocsPerRun(100, func() {
+			router.ServeHTTP(w, req)
+		}); allocs != 0 {
+			t.Errorf("cached lookup allocated %v times; want 0", allocs)
+		}
+	}
+
+	// Hostで作ったRouterも、UseLookupCacheを呼んだ順序にかかわらずキャッシュを使う
+	router = New()
+	before := router.Host("before.example.com")
+	router.UseLookupCache(64)
+	after := router.Host(":tenant.example.com")
+	for _, sub := range []*Router{before, after} {
+		sub.GET("/users/:id", func(http.ResponseWriter, *http.Request, Params) {})
+	}
+	for _, host := range []string{"before.example.com", "acme.example.com"} {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Host = host
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for _, sub := range []*Router{before, after} {
+		if sub.cache == nil || sub.cache.len() != 1 {
+			t.Error("host router did not cache its lookup")
+		}
+	}
+}
+
+func TestLookupCacheEviction(t *testing.T) {
+	c := newLookupCache(3)
+	tables := make([]*methodTable, 4)
+	for i := range tables {
+		tables[i] = new(methodTable)
+	}
+
+	c.add(http.MethodGet, "/0", tables[0], nil)
+	c.add(http.MethodGet, "/1", tables[1], nil)
+	c.add(http.MethodGet, "/2", tables[2], nil)
+	// /0を使ったので、次に追い出されるのは/1になる
+	if table, _, ok := c.get(http.MethodGet, "/0", nil, 0); !ok || table != tables[0] {
+		t.Errorf("get(/0) = %v, %v", table, ok)
+	}
+	c.add(http.MethodGet, "/3", tables[3], nil)
+
+	for i, expected := range []bool{true, false, true, true} {
+		path := "/" + strconv.Itoa(i)
+		if _, _, ok := c.get(http.MethodGet, path, nil, 0); ok != expected {
+			t.Errorf("get(%s) found = %v; want %v", path, ok, expected)
+		}
+	}
+	if _, _, ok := c.get(http.MethodPost, "/0", nil, 0); ok {
+		t.Errorf("get(POST /0) found an entry cached for GET")
+	}
+}
+
+func TestHost(t *testing.T) {
+	router := New()
+	router.HandleMethodNotAllowed = true
+	router.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("default user " + ps.ByName("id")))
+	})
+
+	api := router.Host("api.example.com")
+	api.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("api user " + ps.ByName("id")))
+	})
+	if router.Host("api.example.com") != api {
+		t.Error("Host returned a different router for the same pattern")
+	}
+
+	router.SaveMatchedRoutePath = true
+	tenant := router.Host(":tenant.Example.com")
+	tenant.GET("/users/:id", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte(ps.ByName("tenant") + " user " + ps.ByIndex(0) + " " + ps.ByIndex(1) + " " + ps.ByName(MatchedRoutePathParam)))
+	})
+	tenant.GET("/", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte(ps.ByName("tenant") + " home"))
+	})
+	local := router.Host("localhost:8080")
+	local.GET("/", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		w.Write([]byte("local home"))
+	})
+	if router.Host("localhost") != local {
+		t.Error("Host with a port returned a different router than the same host without it")
+	}
+
+	tests := []struct {
+		method       string
+		host         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{http.MethodGet, "api.example.com", "/users/1", http.StatusOK, "api user 1"},
+		{http.MethodGet, "API.Example.com:8080", "/users/1", http.StatusOK, "api user 1"},
+		{http.MethodGet, "acme.example.com", "/users/2", http.StatusOK, "acme user 2 acme /users/:id"},
+		{http.MethodGet, "acme.example.com.", "/", http.StatusOK, "acme home"},
+		{http.MethodGet, "example.com", "/users/3", http.StatusOK, "default user 3"},
+		{http.MethodGet, "a.b.example.com", "/users/4", http.StatusOK, "default user 4"},
+		{http.MethodPost, "acme.example.com", "/users/2", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{http.MethodGet, "api.example.com", "/", http.StatusNotFound, "404 page not found\n"},
+		{http.MethodGet, "localhost:8080", "/", http.StatusOK, "local home"},
+		{http.MethodGet, "localhost", "/", http.StatusOK, "local home"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Host = test.host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s%s returned status %d; want %d", test.method, test.host, test.path, w.Code, test.expectedCode)
+		}
+		if body := w.Body.String(); body != test.expectedBody {
+			t.Errorf("%s %s%s returned body %q; want %q", test.method, test.host, test.path, body, test.expectedBody)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Host = "status.example.com"
+	router.Host("status.example.com").GET("/health", func(http.ResponseWriter, *http.Request, Params) {})
+	w := newBenchResponseWriter()
+	if !raceEnabled {
+		if allocs := testing.AllocsPerRun(100, func() {
+			router.ServeHTTP(w, req)
+		}); allocs != 0 {
+			t.Errorf("request to a static host allocated %v times; want 0", allocs)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Host = "acme.example.com"
+	tenant.GET("/health", func(http.ResponseWriter, *http.Request, Params) {})
+	if !raceEnabled {
+		if allocs := testing.AllocsPerRun(100, func() {
+			router.ServeHTTP(w, req)
+		}); allocs != 0 {
+			t.Errorf("request to a wildcard host allocated %v times; want 0", allocs)
+		}
+	}
+
+	if recv := catchPanic(func() {
+		router.Host("example.com/api")
+	}); recv == nil {
+		t.Error("Host with a slash did not panic")
+	}
+	if recv := catchPanic(func() {
+		router.Host("a:b.example.com")
+	}); recv == nil {
+		t.Error("Host with a colon inside a label did not panic")
+	}
+}
+
+func TestRouteMatchers(t *testing.T) {
+	router := New()
+	router.HandleMethodNotAllowed = true
+	router.POST("/items", fakeHandler("json"), MatchContentType("application/json"))
+	router.POST("/items", fakeHandler("csv"), MatchContentType("text/csv"))
+	router.POST("/items", fakeHandler("v2-json"), MatchHeader("X-Api-Version", "2"), MatchContentType("application/json"))
+	router.GET("/items", fakeHandler("list"))
+	router.GET("/items", fakeHandler("search"), MatchQuery("q"))
+	router.GET("/admin", fakeHandler("admin"), MatchHeader("x-role", "admin"))
+	router.GET("/admin", fakeHandler("curl"), MatchHeaderRegexp("User-Agent", "^curl/"))
+	router.Any("/hooks/:id", fakeHandler("hook"), MatchFunc(func(req *http.Request) bool {
+		return req.Header.Get("X-Signature") != ""
+	}))
+
+	tests := []struct {
+		method        string
+		path          string
+		header        map[string]string
+		expectedCode  int
+		expectedValue string
+	}{
+		{http.MethodPost, "/items", map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusOK, "json"},
+		{http.MethodPost, "/items", map[string]string{"Content-Type": "text/csv"}, http.StatusOK, "csv"},
+		{http.MethodPost, "/items", map[string]string{"Content-Type": "application/json", "X-Api-Version": "2"}, http.StatusOK, "v2-json"},
+		{http.MethodPost, "/items", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType, ""},
+		{http.MethodPost, "/items", nil, http.StatusUnsupportedMediaType, ""},
+		{http.MethodGet, "/items", nil, http.StatusOK, "list"},
+		{http.MethodGet, "/items?q=go", nil, http.StatusOK, "search"},
+		{http.MethodGet, "/items?page=1&q", nil, http.StatusOK, "search"},
+		{http.MethodGet, "/items?qq=go", nil, http.StatusOK, "list"},
+		{http.MethodDelete, "/items", nil, http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/admin", map[string]string{"X-Role": "admin"}, http.StatusOK, "admin"},
+		{http.MethodGet, "/admin", map[string]string{"User-Agent": "curl/8.0"}, http.StatusOK, "curl"},
+		{http.MethodGet, "/admin", map[string]string{"X-Role": "user"}, http.StatusNotFound, ""},
+		{http.MethodPut, "/hooks/1", map[string]string{"X-Signature": "abc"}, http.StatusOK, "hook"},
+		{http.MethodPut, "/hooks/1", nil, http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue = ""
+		req := httptest.NewRequest(test.method, test.path, nil)
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s %v returned status %d; want %d", test.method, test.path, test.header, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("%s %s %v called %q handler; want %q", test.method, test.path, test.header, fakeHandlerValue, test.expectedValue)
+		}
+	}
+}
+
+func TestProduces(t *testing.T) {
+	router := New()
+	router.GET("/report", fakeHandler("json"), Produces("application/json"))
+	router.GET("/report", fakeHandler("html"), Produces("text/html"))
+	router.GET("/report", fakeHandler("csv"), Produces("text/csv"))
+	router.GET("/data", fakeHandler("default"))
+	router.GET("/data", fakeHandler("csv"), Produces("Text/CSV"))
+	router.GET("/plain", fakeHandler("plain"))
+
+	tests := []struct {
+		path          string
+		accept        string
+		expectedCode  int
+		expectedValue string
+		expectedVary  string
+	}{
+		{"/report", "application/json", http.StatusOK, "json", "Accept"},
+		{"/report", "text/html;q=0.9, application/json;q=0.5", http.StatusOK, "html", "Accept"},
+		{"/report", "text/*", http.StatusOK, "html", "Accept"},
+		{"/report", "text/*;q=0.5, text/csv", http.StatusOK, "csv", "Accept"},
+		{"/report", "*/*", http.StatusOK, "json", "Accept"},
+		{"/report", "", http.StatusOK, "json", "Accept"},
+		{"/report", "application/json;q=0, */*", http.StatusOK, "html", "Accept"},
+		{"/report", "image/png", http.StatusNotAcceptable, "", "Accept"},
+		{"/data", "text/csv", http.StatusOK, "csv", "Accept"},
+		{"/data", "application/json", http.StatusOK, "default", "Accept"},
+		{"/plain", "application/json", http.StatusOK, "plain", ""},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue = ""
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("GET %s (Accept: %s) returned status %d; want %d", test.path, test.accept, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("GET %s (Accept: %s) called %q handler; want %q", test.path, test.accept, fakeHandlerValue, test.expectedValue)
+		}
+		if vary := w.Header().Get("Vary"); vary != test.expectedVary {
+			t.Errorf("GET %s (Accept: %s) returned Vary %q; want %q", test.path, test.accept, vary, test.expectedVary)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	router := New()
+	router.Versioning = Versioning{
+		PathPrefix: true,
+		Header:     "X-API-Version",
+		MediaType:  "application/vnd.example",
+	}
+	router.GET("/users/:id", fakeHandler("v1"), APIVersion(1))
+	router.GET("/users/:id", fakeHandler("v3"), APIVersion(3))
+	router.GET("/legacy", fakeHandler("legacy"))
+	router.GET("/orders", fakeHandler("orders-v2"), APIVersion(2))
+	router.DeprecateVersion(1, time.Date(2024, 6, 30, 23, 59, 59, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		path          string
+		header        string
+		accept        string
+		expectedCode  int
+		expectedValue string
+	}{
+		{"/users/1", "", "", http.StatusOK, "v3"},
+		{"/v1/users/1", "", "", http.StatusOK, "v1"},
+		{"/v2/users/1", "", "", http.StatusOK, "v1"},
+		{"/v4/users/1", "", "", http.StatusOK, "v3"},
+		{"/users/1", "1", "", http.StatusOK, "v1"},
+		{"/users/1", "v3", "", http.StatusOK, "v3"},
+		{"/users/1", "", "application/vnd.example.v2+json", http.StatusOK, "v1"},
+		{"/v3/users/1", "1", "", http.StatusOK, "v3"},
+		{"/v1/legacy", "", "", http.StatusOK, "legacy"},
+		{"/legacy", "", "", http.StatusOK, "legacy"},
+		{"/v1/orders", "", "", http.StatusNotFound, ""},
+		{"/v2/orders", "", "", http.StatusOK, "orders-v2"},
+		{"/va/users/1", "", "", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue = ""
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.header != "" {
+			req.Header.Set("X-API-Version", test.header)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("GET %s (version %q, Accept %q) returned status %d; want %d", test.path, test.header, test.accept, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("GET %s (version %q, Accept %q) called %q handler; want %q", test.path, test.header, test.accept, fakeHandlerValue, test.expectedValue)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/users/1", nil))
+	if got, want := w.Header().Get("Deprecation"), "@1719791999"; got != want {
+		t.Errorf("Deprecation header = %q; want %q", got, want)
+	}
+	if got, want := w.Header().Get("Sunset"), "Wed, 01 Jan 2025 00:00:00 GMT"; got != want {
+		t.Errorf("Sunset header = %q; want %q", got, want)
+	}
+	if got, want := w.Header().Values("Vary"), []string{"X-API-Version", "Accept"}; !slices.Equal(got, want) {
+		t.Errorf("Vary header = %q; want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if got := w.Header().Get("Deprecation"); got != "" {
+		t.Errorf("latest version returned Deprecation header %q", got)
+	}
+
+	// 正規化したパスからもバージョンを取り出して探す
+	router.RedirectFixedPath = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2//orders", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/v2/orders" {
+		t.Errorf("GET /v2//orders returned status %d and Location %q; want a redirect to /v2/orders", w.Code, w.Header().Get("Location"))
+	}
+	router.RedirectFixedPath = false
+	router.ServeCleanedPath = true
+	fakeHandlerValue = ""
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2//orders", nil))
+	if w.Code != http.StatusOK || fakeHandlerValue != "orders-v2" {
+		t.Errorf("GET /v2//orders returned status %d and called %q handler; want orders-v2", w.Code, fakeHandlerValue)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	router := New()
+	router.Redirect("/old/:id/*rest", "/new/:id/*rest", http.StatusMovedPermanently)
+	router.Redirect("/legacy", "/current", http.StatusFound)
+	router.Redirect("/u/:name", "/users/:name/profile", http.StatusPermanentRedirect)
+	router.GET("/u/:name/edit", fakeHandler("edit"))
+	router.Redirect("/moved/*rest", "/*rest", http.StatusMovedPermanently)
+
+	tests := []struct {
+		method           string
+		url              string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{http.MethodGet, "/moved/docs", http.StatusMovedPermanently, "/docs"},
+		{http.MethodGet, "/moved//evil.com", http.StatusMovedPermanently, "/evil.com"},
+		{http.MethodGet, "/moved///evil.com/x", http.StatusMovedPermanently, "/evil.com/x"},
+		{http.MethodGet, "/old/42/a/b.txt", http.StatusMovedPermanently, "/new/42/a/b.txt"},
+		{http.MethodGet, "/old/42/a?x=1&y=2", http.StatusMovedPermanently, "/new/42/a?x=1&y=2"},
+		{http.MethodGet, "/legacy", http.StatusFound, "/current"},
+		{http.MethodGet, "/legacy?q=go", http.StatusFound, "/current?q=go"},
+		{http.MethodPost, "/u/alice", http.StatusPermanentRedirect, "/users/alice/profile"},
+		{http.MethodGet, "/u/a%20b", http.StatusPermanentRedirect, "/users/a%20b/profile"},
+		{http.MethodGet, "/u/alice/edit", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.url, w.Code, test.expectedCode)
+		}
+		if location := w.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("%s %s redirected to %q; want %q", test.method, test.url, location, test.expectedLocation)
+		}
+	}
+
+	for _, to := range []string{"/new/:missing", "new"} {
+		to := to
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Redirect to %q did not panic", to)
+				}
+			}()
+			router.Redirect("/gone/:id", to, http.StatusMovedPermanently)
+		}()
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	router := New()
+	router.SaveMatchedRoutePath = true
+	router.Rewrite("/u/:name", "/users/:name")
+	router.Rewrite("/static/*filepath", "/assets/*filepath")
+	router.Rewrite("/home", "/")
+
+	var gotPath, gotName, gotMatched, gotOriginal string
+	handle := func(w http.ResponseWriter, req *http.Request, ps Params) {
+		gotPath = req.URL.Path
+		gotName = ps.ByName("name") + ps.ByName("filepath")
+		gotMatched = ps.ByName(MatchedRoutePathParam)
+		gotOriginal = OriginalPathFromContext(req.Context())
+	}
+	router.GET("/users/:name", handle)
+	router.GET("/assets/*filepath", handle)
+	router.GET("/", handle)
+
+	tests := []struct {
+		url              string
+		expectedPath     string
+		expectedValue    string
+		expectedMatched  string
+		expectedOriginal string
+	}{
+		{"/u/alice", "/users/alice", "alice", "/users/:name", "/u/alice"},
+		{"/users/bob", "/users/bob", "bob", "/users/:name", ""},
+		{"/static/css/app.css", "/assets/css/app.css", "/css/app.css", "/assets/*filepath", "/static/css/app.css"},
+		{"/home", "/", "", "/", "/home"},
+	}
+
+	for _, test := range tests {
+		gotPath, gotName, gotMatched, gotOriginal = "", "", "", ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s returned status %d; want %d", test.url, w.Code, http.StatusOK)
+		}
+		if gotPath != test.expectedPath {
+			t.Errorf("GET %s served path %q; want %q", test.url, gotPath, test.expectedPath)
+		}
+		if gotName != test.expectedValue {
+			t.Errorf("GET %s captured %q; want %q", test.url, gotName, test.expectedValue)
+		}
+		if gotMatched != test.expectedMatched {
+			t.Errorf("GET %s matched route %q; want %q", test.url, gotMatched, test.expectedMatched)
+		}
+		if gotOriginal != test.expectedOriginal {
+			t.Errorf("GET %s kept original path %q; want %q", test.url, gotOriginal, test.expectedOriginal)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/u/alice/extra", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /u/alice/extra returned status %d; want %d", w.Code, http.StatusNotFound)
+	}
+
+	// 正規化したパスにも書き換えを適用し、リダイレクト先は書き換える前のパスにする
+	router.RedirectFixedPath = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/u//carol", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/u/carol" {
+		t.Errorf("GET /u//carol returned status %d and Location %q; want a redirect to /u/carol", w.Code, w.Header().Get("Location"))
+	}
+	router.RedirectFixedPath = false
+	router.ServeCleanedPath = true
+	gotPath, gotName, gotOriginal = "", "", ""
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/u//carol", nil))
+	if w.Code != http.StatusOK || gotPath != "/users/carol" || gotName != "carol" || gotOriginal != "/u/carol" {
+		t.Errorf("GET /u//carol returned status %d, path %q, value %q and original %q; want /users/carol", w.Code, gotPath, gotName, gotOriginal)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		opts     CleanOptions
+		expected string
+	}{
+		{"", CleanOptions{}, "/"},
+		{"/", CleanOptions{}, "/"},
+		{"/abc", CleanOptions{}, "/abc"},
+		{"/abc/", CleanOptions{}, "/abc"},
+		{"/abc/", CleanOptions{PreserveTrailingSlash: true}, "/abc/"},
+		{"abc/def", CleanOptions{}, "/abc/def"},
+		{"//abc//def//", CleanOptions{}, "/abc/def"},
+		{"//abc//def//", CleanOptions{PreserveTrailingSlash: true}, "/abc/def/"},
+		{"/abc/./def", CleanOptions{}, "/abc/def"},
+		{"/abc/def/..", CleanOptions{}, "/abc"},
+		{"/abc/def/../", CleanOptions{PreserveTrailingSlash: true}, "/abc/"},
+		{"/abc/../../def", CleanOptions{}, "/def"},
+		{"/..", CleanOptions{}, "/"},
+		{"/./", CleanOptions{PreserveTrailingSlash: true}, "/"},
+		{"/.abc/..def", CleanOptions{}, "/.abc/..def"},
+		{"/ABC/Def", CleanOptions{Lowercase: true}, "/abc/def"},
+		{"/ABC//Def/", CleanOptions{Lowercase: true, PreserveTrailingSlash: true}, "/abc/def/"},
+	}
+
+	for _, test := range tests {
+		if got := CleanPath(test.path, test.opts); got != test.expected {
+			t.Errorf("CleanPath(%q, %+v) = %q; want %q", test.path, test.opts, got, test.expected)
+		}
+	}
+
+	if raceEnabled {
+		return
+	}
+	for _, opts := range []CleanOptions{{}, {Lowercase: true, PreserveTrailingSlash: true}} {
+		for _, p := range []string{"/abc/def", "/abc/def/", "/a/b/c/d"} {
+			p, opts := p, opts
+			if allocs := testing.AllocsPerRun(100, func() { CleanPath(p, opts) }); allocs != 0 {
+				t.Errorf("CleanPath(%q, %+v) allocated %v times; want 0", p, opts, allocs)
+			}
+		}
+	}
+}
+
+func TestFixedPath(t *testing.T) {
+	router := New()
+	router.RedirectFixedPath = true
+	router.GET("/users/", fakeHandler("users"))
+	router.GET("/users/:id", fakeHandler("user"))
+	router.GET("/docs", fakeHandler("docs"))
+
+	tests := []struct {
+		path             string
+		opts             CleanOptions
+		serve            bool
+		expectedCode     int
+		expectedLocation string
+		expectedValue    string
+	}{
+		{"//users/", CleanOptions{PreserveTrailingSlash: true}, false, http.StatusMovedPermanently, "/users/", ""},
+		{"/x/../users/1", CleanOptions{}, false, http.StatusMovedPermanently, "/users/1", ""},
+		{"/docs/", CleanOptions{}, false, http.StatusMovedPermanently, "/docs", ""},
+		{"/docs/", CleanOptions{PreserveTrailingSlash: true}, false, http.StatusNotFound, "", ""},
+		{"/DOCS", CleanOptions{Lowercase: true}, false, http.StatusMovedPermanently, "/docs", ""},
+		{"//users/", CleanOptions{PreserveTrailingSlash: true}, true, http.StatusOK, "", "users"},
+		{"/a/./../users/2", CleanOptions{}, true, http.StatusOK, "", "user"},
+		{"/Docs", CleanOptions{Lowercase: true}, true, http.StatusOK, "", "docs"},
+	}
+
+	for _, test := range tests {
+		router.PathCleaning = test.opts
+		router.ServeCleanedPath = test.serve
+		fakeHandlerValue = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = test.path
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedCode {
+			t.Errorf("GET %s (%+v, serve %v) returned status %d; want %d", test.path, test.opts, test.serve, w.Code, test.expectedCode)
+		}
+		if location := w.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("GET %s (%+v, serve %v) redirected to %q; want %q", test.path, test.opts, test.serve, location, test.expectedLocation)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("GET %s (%+v, serve %v) called %q handler; want %q", test.path, test.opts, test.serve, fakeHandlerValue, test.expectedValue)
+		}
+	}
+}
+
+func TestRawPath(t *testing.T) {
+	var gotKey string
+	handle := func(value string) Handle {
+		return func(w http.ResponseWriter, req *http.Request, ps Params) {
+			fakeHandlerValue = value
+			gotKey = ps.ByName("key")
+		}
+	}
+	newRouter := func(useRawPath, unescape bool) *Router {
+		router := New()
+		router.UseRawPath = useRawPath
+		router.UnescapePathValues = unescape
+		router.GET("/objects/:key", handle("object"))
+		router.GET("/objects/:key/meta", handle("meta"))
+		router.Redirect("/old/:key", "/objects/:key", http.StatusMovedPermanently)
+		router.Redirect("/archive/*path", "/objects/*path", http.StatusMovedPermanently)
+		return router
+	}
+
+	tests := []struct {
+		useRawPath       bool
+		unescape         bool
+		url              string
+		expectedCode     int
+		expectedValue    string
+		expectedKey      string
+		expectedLocation string
+	}{
+		{false, false, "/objects/a%2Fb", http.StatusNotFound, "", "", ""},
+		{true, false, "/objects/a%2Fb", http.StatusOK, "object", "a%2Fb", ""},
+		{true, true, "/objects/a%2Fb", http.StatusOK, "object", "a/b", ""},
+		{true, true, "/objects/a%2Fb/meta", http.StatusOK, "meta", "a/b", ""},
+		{true, true, "/objects/a%20b", http.StatusOK, "object", "a b", ""},
+		{true, true, "/objects/plain", http.StatusOK, "object", "plain", ""},
+		{true, false, "/old/a%2Fb", http.StatusMovedPermanently, "", "", "/objects/a%2Fb"},
+		{true, true, "/old/a%2Fb", http.StatusMovedPermanently, "", "", "/objects/a%2Fb"},
+		{true, true, "/archive/x/a%20c", http.StatusMovedPermanently, "", "", "/objects/x/a%20c"},
+		{true, false, "/archive/x/a%2Fb", http.StatusMovedPermanently, "", "", "/objects/x/a%2Fb"},
+		{false, false, "/archive/x/a%20c", http.StatusMovedPermanently, "", "", "/objects/x/a%20c"},
+	}
+
+	for _, test := range tests {
+		router := newRouter(test.useRawPath, test.unescape)
+		fakeHandlerValue, gotKey = "", ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+
+		if w.Code != test.expectedCode {
+			t.Errorf("GET %s (raw %v, unescape %v) returned status %d; want %d", test.url, test.useRawPath, test.unescape, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("GET %s (raw %v, unescape %v) called %q handler; want %q", test.url, test.useRawPath, test.unescape, fakeHandlerValue, test.expectedValue)
+		}
+		if gotKey != test.expectedKey {
+			t.Errorf("GET %s (raw %v, unescape %v) captured key %q; want %q", test.url, test.useRawPath, test.unescape, gotKey, test.expectedKey)
+		}
+		if location := w.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("GET %s (raw %v, unescape %v) redirected to %q; want %q", test.url, test.useRawPath, test.unescape, location, test.expectedLocation)
+		}
+	}
+}
+
+func TestTrailingSlashPolicy(t *testing.T) {
+	router := New()
+	router.GET("/strict", fakeHandler("strict"))
+	router.GET("/redirect", fakeHandler("redirect"), TrailingSlash(TrailingSlashRedirect))
+	router.POST("/redirect", fakeHandler("redirect-post"), TrailingSlash(TrailingSlashRedirect))
+	router.GET("/accept/", fakeHandler("accept"), TrailingSlash(TrailingSlashAccept))
+	router.GET("/explicit", fakeHandler("explicit"))
+	router.GET("/explicit/", fakeHandler("explicit-slash"), TrailingSlash(TrailingSlashAccept))
+
+	api := router.Group("/api", TrailingSlash(TrailingSlashAccept))
+	api.GET("/users/:id", fakeHandler("user"))
+	api.GET("/items", fakeHandler("items"), TrailingSlash(TrailingSlashStrict))
+	pages := router.Group("/pages", TrailingSlash(TrailingSlashRedirect))
+	pages.Group("/docs").GET("/intro", fakeHandler("intro"))
+
+	tests := []struct {
+		method           string
+		url              string
+		expectedCode     int
+		expectedValue    string
+		expectedLocation string
+	}{
+		{http.MethodGet, "/strict", http.StatusOK, "strict", ""},
+		{http.MethodGet, "/strict/", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/redirect", http.StatusOK, "redirect", ""},
+		{http.MethodGet, "/redirect/?q=1", http.StatusMovedPermanently, "", "/redirect?q=1"},
+		{http.MethodPost, "/redirect/", http.StatusPermanentRedirect, "", "/redirect"},
+		{http.MethodGet, "/accept/", http.StatusOK, "accept", ""},
+		{http.MethodGet, "/accept", http.StatusOK, "accept", ""},
+		{http.MethodGet, "/explicit", http.StatusOK, "explicit", ""},
+		{http.MethodGet, "/explicit/", http.StatusOK, "explicit-slash", ""},
+		{http.MethodGet, "/api/users/1", http.StatusOK, "user", ""},
+		{http.MethodGet, "/api/users/1/", http.StatusOK, "user", ""},
+		{http.MethodGet, "/api/items", http.StatusOK, "items", ""},
+		{http.MethodGet, "/api/items/", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/pages/docs/intro/", http.StatusMovedPermanently, "", "/pages/docs/intro"},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue = ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.url, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("%s %s called %q handler; want %q", test.method, test.url, fakeHandlerValue, test.expectedValue)
+		}
+		if location := w.Header().Get("Location"); location != test.expectedLocation {
+			t.Errorf("%s %s redirected to %q; want %q", test.method, test.url, location, test.expectedLocation)
+		}
+	}
+}
+
+func TestHandlePattern(t *testing.T) {
+	router := New()
+	var got string
+	handle := func(value string) Handle {
+		return func(w http.ResponseWriter, req *http.Request, ps Params) {
+			fakeHandlerValue = value
+			got = ps.ByName("id") + "|" + ps.ByName("path")
+		}
+	}
+	router.HandlePattern("GET /users/{id}", handle("user"))
+	router.HandlePattern("POST /users/{id}/posts", handle("post"))
+	router.HandlePattern("/files/{path...}", handle("file"))
+	router.HandlePattern("GET /docs/{$}", handle("docs-index"))
+	router.HandlePattern("GET /static/", handle("static"))
+	router.HandlePattern("GET api.example.com/items/{id}", handle("item"))
+	router.HandlePattern("HEAD /orders/{id}", handle("order-head"))
+	router.HandlerPattern("GET /orders/{id}", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fakeHandlerValue = "order"
+		got = ParamsFromContext(req.Context()).ByName("id") + "|"
+	}))
+
+	tests := []struct {
+		method        string
+		url           string
+		expectedCode  int
+		expectedValue string
+		expectedGot   string
+	}{
+		{http.MethodGet, "/users/42", http.StatusOK, "user", "42|"},
+		{http.MethodPost, "/users/42", http.StatusNotFound, "", ""},
+		{http.MethodPost, "/users/7/posts", http.StatusOK, "post", "7|"},
+		{http.MethodGet, "/files/a/b.txt", http.StatusOK, "file", "|a/b.txt"},
+		{http.MethodDelete, "/files/", http.StatusOK, "file", "|"},
+		{http.MethodGet, "/docs/", http.StatusOK, "docs-index", "|"},
+		{http.MethodGet, "/docs/intro", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/static/", http.StatusOK, "static", "|"},
+		{http.MethodGet, "/static/css/app.css", http.StatusOK, "static", "|"},
+		{http.MethodGet, "http://api.example.com/items/3", http.StatusOK, "item", "3|"},
+		{http.MethodGet, "/items/3", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/orders/9", http.StatusOK, "order", "9|"},
+		{http.MethodHead, "/users/42", http.StatusOK, "user", "42|"},
+		{http.MethodHead, "/orders/9", http.StatusOK, "order-head", "9|"},
+		{http.MethodHead, "/users/7/posts", http.StatusNotFound, "", ""},
+	}
+
+	for _, test := range tests {
+		fakeHandlerValue, got = "", ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+
+		if w.Code != test.expectedCode {
+			t.Errorf("%s %s returned status %d; want %d", test.method, test.url, w.Code, test.expectedCode)
+		}
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("%s %s called %q handler; want %q", test.method, test.url, fakeHandlerValue, test.expectedValue)
+		}
+		if got != test.expectedGot {
+			t.Errorf("%s %s captured %q; want %q", test.method, test.url, got, test.expectedGot)
+		}
+	}
+
+	for _, pattern := range []string{
+		"GET users",
+		"/users/{id}x",
+		"/users/{1id}",
+		"/users/{id}/{id}",
+		"/files/{path...}/meta",
+		"/docs/{$}/more",
+		"/users/:id",
+	} {
+		pattern := pattern
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("HandlePattern(%q) did not panic", pattern)
+				}
+			}()
+			New().HandlePattern(pattern, handle(""))
+		}()
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static?v=1", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/static/?v=1" {
+		t.Errorf("GET /static returned status %d and Location %q; want a redirect to %q", w.Code, w.Header().Get("Location"), "/static/?v=1")
+	}
+
+	var params Params
+	subtree := New()
+	subtree.HandlePattern("GET /tenants/{id}/", func(w http.ResponseWriter, req *http.Request, ps Params) {
+		params = ps.Clone()
+	})
+	subtree.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tenants/7/a/b", nil))
+	if params.Len() != 1 || params.ByIndex(0) != "7" || params.ByName("id") != "7" || params.ByName(subtreeParam) != "" {
+		t.Errorf("GET /tenants/7/a/b passed params %+v; want only id=7", params)
+	}
+	w = httptest.NewRecorder()
+	subtree.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/tenants/7", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/tenants/7/" {
+		t.Errorf("HEAD /tenants/7 returned status %d and Location %q; want a redirect to %q", w.Code, w.Header().Get("Location"), "/tenants/7/")
+	}
+
+	conflicts := New()
+	conflicts.HandlePattern("GET /users/{id}", handle("user"))
+	recv := catchPanic(func() {
+		conflicts.HandlePattern("GET /users/new", handle("new"))
+	})
+	msg, _ := recv.(string)
+	if !strings.Contains(msg, "'GET /users/new'") || !strings.Contains(msg, "'GET /users/{id}'") {
+		t.Errorf("conflicting patterns panicked with %#v; want a message naming both patterns", recv)
+	}
+}
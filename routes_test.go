@@ -0,0 +1,429 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import "net/http"
+
+// ベンチマーク用のルート集
+// GitHub APIとParse APIはgo-http-routing-benchmarkで使われているものを元にしている
+
+var staticRoutes = []benchRoute{
+	{http.MethodGet, "/"},
+	{http.MethodGet, "/cmd.html"},
+	{http.MethodGet, "/code.html"},
+	{http.MethodGet, "/contrib.html"},
+	{http.MethodGet, "/contribute.html"},
+	{http.MethodGet, "/debugging_with_gdb.html"},
+	{http.MethodGet, "/docs.html"},
+	{http.MethodGet, "/effective_go.html"},
+	{http.MethodGet, "/files.log"},
+	{http.MethodGet, "/gccgo_contribute.html"},
+	{http.MethodGet, "/gccgo_install.html"},
+	{http.MethodGet, "/go-logo-black.png"},
+	{http.MethodGet, "/go-logo-blue.png"},
+	{http.MethodGet, "/go-logo-white.png"},
+	{http.MethodGet, "/go1.1.html"},
+	{http.MethodGet, "/go1.2.html"},
+	{http.MethodGet, "/go1.html"},
+	{http.MethodGet, "/go1compat.html"},
+	{http.MethodGet, "/go_faq.html"},
+	{http.MethodGet, "/go_mem.html"},
+	{http.MethodGet, "/go_spec.html"},
+	{http.MethodGet, "/help.html"},
+	{http.MethodGet, "/ie.css"},
+	{http.MethodGet, "/install-source.html"},
+	{http.MethodGet, "/install.html"},
+	{http.MethodGet, "/logo-153x55.png"},
+	{http.MethodGet, "/Makefile"},
+	{http.MethodGet, "/root.html"},
+	{http.MethodGet, "/share.png"},
+	{http.MethodGet, "/sieve.gif"},
+	{http.MethodGet, "/tos.html"},
+	{http.MethodGet, "/articles/"},
+	{http.MethodGet, "/articles/go_command.html"},
+	{http.MethodGet, "/articles/index.html"},
+	{http.MethodGet, "/articles/wiki/"},
+	{http.MethodGet, "/articles/wiki/edit.html"},
+	{http.MethodGet, "/articles/wiki/final-noclosure.go"},
+	{http.MethodGet, "/articles/wiki/final-noerror.go"},
+	{http.MethodGet, "/articles/wiki/final-parsetemplate.go"},
+	{http.MethodGet, "/articles/wiki/final-template.go"},
+	{http.MethodGet, "/articles/wiki/final.go"},
+	{http.MethodGet, "/articles/wiki/get.go"},
+	{http.MethodGet, "/articles/wiki/http-sample.go"},
+	{http.MethodGet, "/articles/wiki/index.html"},
+	{http.MethodGet, "/articles/wiki/Makefile"},
+	{http.MethodGet, "/articles/wiki/notemplate.go"},
+	{http.MethodGet, "/articles/wiki/part1-noerror.go"},
+	{http.MethodGet, "/articles/wiki/part1.go"},
+	{http.MethodGet, "/articles/wiki/part2.go"},
+	{http.MethodGet, "/articles/wiki/part3-errorhandling.go"},
+	{http.MethodGet, "/articles/wiki/part3.go"},
+	{http.MethodGet, "/articles/wiki/test.bash"},
+	{http.MethodGet, "/articles/wiki/test_edit.good"},
+	{http.MethodGet, "/articles/wiki/test_Test.txt.good"},
+	{http.MethodGet, "/articles/wiki/test_view.good"},
+	{http.MethodGet, "/articles/wiki/view.html"},
+	{http.MethodGet, "/codewalk/"},
+	{http.MethodGet, "/codewalk/codewalk.css"},
+	{http.MethodGet, "/codewalk/codewalk.js"},
+	{http.MethodGet, "/codewalk/codewalk.xml"},
+	{http.MethodGet, "/codewalk/functions.xml"},
+	{http.MethodGet, "/codewalk/markov.go"},
+	{http.MethodGet, "/codewalk/markov.xml"},
+	{http.MethodGet, "/codewalk/pig.go"},
+	{http.MethodGet, "/codewalk/popout.png"},
+	{http.MethodGet, "/codewalk/run"},
+	{http.MethodGet, "/codewalk/sharemem.xml"},
+	{http.MethodGet, "/codewalk/urlpoll.go"},
+	{http.MethodGet, "/devel/"},
+	{http.MethodGet, "/devel/release.html"},
+	{http.MethodGet, "/devel/weekly.html"},
+	{http.MethodGet, "/gopher/"},
+	{http.MethodGet, "/gopher/appenginegopher.jpg"},
+	{http.MethodGet, "/gopher/appenginegophercolor.jpg"},
+	{http.MethodGet, "/gopher/appenginelogo.gif"},
+	{http.MethodGet, "/gopher/bumper.png"},
+	{http.MethodGet, "/gopher/bumper192x108.png"},
+	{http.MethodGet, "/gopher/bumper320x180.png"},
+	{http.MethodGet, "/gopher/bumper480x270.png"},
+	{http.MethodGet, "/gopher/bumper640x360.png"},
+	{http.MethodGet, "/gopher/doc.png"},
+	{http.MethodGet, "/gopher/frontpage.png"},
+	{http.MethodGet, "/gopher/gopherbw.png"},
+	{http.MethodGet, "/gopher/gophercolor.png"},
+	{http.MethodGet, "/gopher/gophercolor16x16.png"},
+	{http.MethodGet, "/gopher/help.png"},
+	{http.MethodGet, "/gopher/pkg.png"},
+	{http.MethodGet, "/gopher/project.png"},
+	{http.MethodGet, "/gopher/ref.png"},
+	{http.MethodGet, "/gopher/run.png"},
+	{http.MethodGet, "/gopher/talks.png"},
+	{http.MethodGet, "/gopher/pencil/"},
+	{http.MethodGet, "/gopher/pencil/gopherhat.jpg"},
+	{http.MethodGet, "/gopher/pencil/gopherhelmet.jpg"},
+	{http.MethodGet, "/gopher/pencil/gophermega.jpg"},
+	{http.MethodGet, "/gopher/pencil/gopherrunning.jpg"},
+	{http.MethodGet, "/gopher/pencil/gopherswim.jpg"},
+	{http.MethodGet, "/gopher/pencil/gopherswrench.jpg"},
+	{http.MethodGet, "/play/"},
+	{http.MethodGet, "/play/fib.go"},
+	{http.MethodGet, "/play/hello.go"},
+	{http.MethodGet, "/play/life.go"},
+	{http.MethodGet, "/play/peano.go"},
+	{http.MethodGet, "/play/pi.go"},
+	{http.MethodGet, "/play/sieve.go"},
+	{http.MethodGet, "/play/solitaire.go"},
+	{http.MethodGet, "/play/tree.go"},
+	{http.MethodGet, "/progs/"},
+	{http.MethodGet, "/progs/cgo1.go"},
+	{http.MethodGet, "/progs/cgo2.go"},
+	{http.MethodGet, "/progs/cgo3.go"},
+	{http.MethodGet, "/progs/cgo4.go"},
+	{http.MethodGet, "/progs/defer.go"},
+	{http.MethodGet, "/progs/defer.out"},
+	{http.MethodGet, "/progs/defer2.go"},
+	{http.MethodGet, "/progs/defer2.out"},
+	{http.MethodGet, "/progs/eff_bytesize.go"},
+	{http.MethodGet, "/progs/eff_bytesize.out"},
+	{http.MethodGet, "/progs/eff_qr.go"},
+	{http.MethodGet, "/progs/eff_sequence.go"},
+	{http.MethodGet, "/progs/eff_sequence.out"},
+	{http.MethodGet, "/progs/error.go"},
+	{http.MethodGet, "/progs/error2.go"},
+	{http.MethodGet, "/progs/error3.go"},
+	{http.MethodGet, "/progs/error4.go"},
+	{http.MethodGet, "/progs/go1.go"},
+	{http.MethodGet, "/progs/gobs1.go"},
+	{http.MethodGet, "/progs/gobs2.go"},
+	{http.MethodGet, "/progs/image_draw.go"},
+	{http.MethodGet, "/progs/image_package1.go"},
+	{http.MethodGet, "/progs/image_package1.out"},
+	{http.MethodGet, "/progs/interface.go"},
+	{http.MethodGet, "/progs/interface2.go"},
+	{http.MethodGet, "/progs/interface2.out"},
+	{http.MethodGet, "/progs/json1.go"},
+	{http.MethodGet, "/progs/json2.go"},
+	{http.MethodGet, "/progs/json2.out"},
+	{http.MethodGet, "/progs/json3.go"},
+	{http.MethodGet, "/progs/json4.go"},
+	{http.MethodGet, "/progs/json5.go"},
+	{http.MethodGet, "/progs/run"},
+	{http.MethodGet, "/progs/slices.go"},
+	{http.MethodGet, "/progs/timeout1.go"},
+	{http.MethodGet, "/progs/timeout2.go"},
+	{http.MethodGet, "/progs/update.bash"},
+}
+
+var githubAPIRoutes = []benchRoute{
+	// OAuth Authorizations
+	{http.MethodGet, "/authorizations"},
+	{http.MethodGet, "/authorizations/:id"},
+	{http.MethodPost, "/authorizations"},
+	{http.MethodDelete, "/authorizations/:id"},
+	{http.MethodGet, "/applications/:client_id/tokens/:access_token"},
+	{http.MethodDelete, "/applications/:client_id/tokens"},
+	{http.MethodDelete, "/applications/:client_id/tokens/:access_token"},
+
+	// Activity
+	{http.MethodGet, "/events"},
+	{http.MethodGet, "/repos/:owner/:repo/events"},
+	{http.MethodGet, "/networks/:owner/:repo/events"},
+	{http.MethodGet, "/orgs/:org/events"},
+	{http.MethodGet, "/users/:user/received_events"},
+	{http.MethodGet, "/users/:user/received_events/public"},
+	{http.MethodGet, "/users/:user/events"},
+	{http.MethodGet, "/users/:user/events/public"},
+	{http.MethodGet, "/users/:user/events/orgs/:org"},
+	{http.MethodGet, "/feeds"},
+	{http.MethodGet, "/notifications"},
+	{http.MethodGet, "/repos/:owner/:repo/notifications"},
+	{http.MethodPut, "/notifications"},
+	{http.MethodPut, "/repos/:owner/:repo/notifications"},
+	{http.MethodGet, "/notifications/threads/:id"},
+	{http.MethodGet, "/notifications/threads/:id/subscription"},
+	{http.MethodPut, "/notifications/threads/:id/subscription"},
+	{http.MethodDelete, "/notifications/threads/:id/subscription"},
+	{http.MethodGet, "/repos/:owner/:repo/stargazers"},
+	{http.MethodGet, "/users/:user/starred"},
+	{http.MethodGet, "/user/starred"},
+	{http.MethodGet, "/user/starred/:owner/:repo"},
+	{http.MethodPut, "/user/starred/:owner/:repo"},
+	{http.MethodDelete, "/user/starred/:owner/:repo"},
+	{http.MethodGet, "/repos/:owner/:repo/subscribers"},
+	{http.MethodGet, "/users/:user/subscriptions"},
+	{http.MethodGet, "/user/subscriptions"},
+	{http.MethodGet, "/repos/:owner/:repo/subscription"},
+	{http.MethodPut, "/repos/:owner/:repo/subscription"},
+	{http.MethodDelete, "/repos/:owner/:repo/subscription"},
+	{http.MethodGet, "/user/subscriptions/:owner/:repo"},
+	{http.MethodPut, "/user/subscriptions/:owner/:repo"},
+	{http.MethodDelete, "/user/subscriptions/:owner/:repo"},
+
+	// Gists
+	{http.MethodGet, "/users/:user/gists"},
+	{http.MethodGet, "/gists"},
+	{http.MethodGet, "/gists/:id"},
+	{http.MethodPost, "/gists"},
+	{http.MethodPut, "/gists/:id/star"},
+	{http.MethodDelete, "/gists/:id/star"},
+	{http.MethodGet, "/gists/:id/star"},
+	{http.MethodPost, "/gists/:id/forks"},
+	{http.MethodDelete, "/gists/:id"},
+
+	// Git Data
+	{http.MethodGet, "/repos/:owner/:repo/git/blobs/:sha"},
+	{http.MethodPost, "/repos/:owner/:repo/git/blobs"},
+	{http.MethodGet, "/repos/:owner/:repo/git/commits/:sha"},
+	{http.MethodPost, "/repos/:owner/:repo/git/commits"},
+	{http.MethodGet, "/repos/:owner/:repo/git/refs"},
+	{http.MethodPost, "/repos/:owner/:repo/git/refs"},
+	{http.MethodGet, "/repos/:owner/:repo/git/tags/:sha"},
+	{http.MethodPost, "/repos/:owner/:repo/git/tags"},
+	{http.MethodGet, "/repos/:owner/:repo/git/trees/:sha"},
+	{http.MethodPost, "/repos/:owner/:repo/git/trees"},
+
+	// Issues
+	{http.MethodGet, "/issues"},
+	{http.MethodGet, "/user/issues"},
+	{http.MethodGet, "/orgs/:org/issues"},
+	{http.MethodGet, "/repos/:owner/:repo/issues"},
+	{http.MethodGet, "/repos/:owner/:repo/issues/:number"},
+	{http.MethodPost, "/repos/:owner/:repo/issues"},
+	{http.MethodGet, "/repos/:owner/:repo/assignees"},
+	{http.MethodGet, "/repos/:owner/:repo/assignees/:assignee"},
+	{http.MethodGet, "/repos/:owner/:repo/issues/:number/comments"},
+	{http.MethodPost, "/repos/:owner/:repo/issues/:number/comments"},
+	{http.MethodGet, "/repos/:owner/:repo/issues/:number/events"},
+	{http.MethodGet, "/repos/:owner/:repo/labels"},
+	{http.MethodGet, "/repos/:owner/:repo/labels/:name"},
+	{http.MethodPost, "/repos/:owner/:repo/labels"},
+	{http.MethodDelete, "/repos/:owner/:repo/labels/:name"},
+	{http.MethodGet, "/repos/:owner/:repo/issues/:number/labels"},
+	{http.MethodPost, "/repos/:owner/:repo/issues/:number/labels"},
+	{http.MethodDelete, "/repos/:owner/:repo/issues/:number/labels/:name"},
+	{http.MethodPut, "/repos/:owner/:repo/issues/:number/labels"},
+	{http.MethodDelete, "/repos/:owner/:repo/issues/:number/labels"},
+	{http.MethodGet, "/repos/:owner/:repo/milestones/:number/labels"},
+	{http.MethodGet, "/repos/:owner/:repo/milestones"},
+	{http.MethodGet, "/repos/:owner/:repo/milestones/:number"},
+	{http.MethodPost, "/repos/:owner/:repo/milestones"},
+	{http.MethodDelete, "/repos/:owner/:repo/milestones/:number"},
+
+	// Miscellaneous
+	{http.MethodGet, "/emojis"},
+	{http.MethodGet, "/gitignore/templates"},
+	{http.MethodGet, "/gitignore/templates/:name"},
+	{http.MethodPost, "/markdown"},
+	{http.MethodPost, "/markdown/raw"},
+	{http.MethodGet, "/meta"},
+	{http.MethodGet, "/rate_limit"},
+
+	// Organizations
+	{http.MethodGet, "/users/:user/orgs"},
+	{http.MethodGet, "/user/orgs"},
+	{http.MethodGet, "/orgs/:org"},
+	{http.MethodGet, "/orgs/:org/members"},
+	{http.MethodGet, "/orgs/:org/members/:user"},
+	{http.MethodDelete, "/orgs/:org/members/:user"},
+	{http.MethodGet, "/orgs/:org/public_members"},
+	{http.MethodGet, "/orgs/:org/public_members/:user"},
+	{http.MethodPut, "/orgs/:org/public_members/:user"},
+	{http.MethodDelete, "/orgs/:org/public_members/:user"},
+	{http.MethodGet, "/orgs/:org/teams"},
+	{http.MethodGet, "/teams/:id"},
+	{http.MethodPost, "/orgs/:org/teams"},
+	{http.MethodDelete, "/teams/:id"},
+	{http.MethodGet, "/teams/:id/members"},
+	{http.MethodGet, "/teams/:id/members/:user"},
+	{http.MethodPut, "/teams/:id/members/:user"},
+	{http.MethodDelete, "/teams/:id/members/:user"},
+	{http.MethodGet, "/teams/:id/repos"},
+	{http.MethodGet, "/teams/:id/repos/:owner/:repo"},
+	{http.MethodPut, "/teams/:id/repos/:owner/:repo"},
+	{http.MethodDelete, "/teams/:id/repos/:owner/:repo"},
+	{http.MethodGet, "/user/teams"},
+
+	// Pull Requests
+	{http.MethodGet, "/repos/:owner/:repo/pulls"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number"},
+	{http.MethodPost, "/repos/:owner/:repo/pulls"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number/commits"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number/files"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number/merge"},
+	{http.MethodPut, "/repos/:owner/:repo/pulls/:number/merge"},
+	{http.MethodGet, "/repos/:owner/:repo/pulls/:number/comments"},
+	{http.MethodPut, "/repos/:owner/:repo/pulls/:number/comments"},
+
+	// Repositories
+	{http.MethodGet, "/user/repos"},
+	{http.MethodGet, "/users/:user/repos"},
+	{http.MethodGet, "/orgs/:org/repos"},
+	{http.MethodGet, "/repositories"},
+	{http.MethodPost, "/user/repos"},
+	{http.MethodPost, "/orgs/:org/repos"},
+	{http.MethodGet, "/repos/:owner/:repo"},
+	{http.MethodGet, "/repos/:owner/:repo/contributors"},
+	{http.MethodGet, "/repos/:owner/:repo/languages"},
+	{http.MethodGet, "/repos/:owner/:repo/teams"},
+	{http.MethodGet, "/repos/:owner/:repo/tags"},
+	{http.MethodGet, "/repos/:owner/:repo/branches"},
+	{http.MethodGet, "/repos/:owner/:repo/branches/:branch"},
+	{http.MethodDelete, "/repos/:owner/:repo"},
+	{http.MethodGet, "/repos/:owner/:repo/collaborators"},
+	{http.MethodGet, "/repos/:owner/:repo/collaborators/:user"},
+	{http.MethodPut, "/repos/:owner/:repo/collaborators/:user"},
+	{http.MethodDelete, "/repos/:owner/:repo/collaborators/:user"},
+	{http.MethodGet, "/repos/:owner/:repo/comments"},
+	{http.MethodGet, "/repos/:owner/:repo/commits/:sha/comments"},
+	{http.MethodPost, "/repos/:owner/:repo/commits/:sha/comments"},
+	{http.MethodGet, "/repos/:owner/:repo/comments/:id"},
+	{http.MethodDelete, "/repos/:owner/:repo/comments/:id"},
+	{http.MethodGet, "/repos/:owner/:repo/commits"},
+	{http.MethodGet, "/repos/:owner/:repo/commits/:sha"},
+	{http.MethodGet, "/repos/:owner/:repo/readme"},
+	{http.MethodGet, "/repos/:owner/:repo/keys"},
+	{http.MethodGet, "/repos/:owner/:repo/keys/:id"},
+	{http.MethodPost, "/repos/:owner/:repo/keys"},
+	{http.MethodDelete, "/repos/:owner/:repo/keys/:id"},
+	{http.MethodGet, "/repos/:owner/:repo/downloads"},
+	{http.MethodGet, "/repos/:owner/:repo/downloads/:id"},
+	{http.MethodDelete, "/repos/:owner/:repo/downloads/:id"},
+	{http.MethodGet, "/repos/:owner/:repo/forks"},
+	{http.MethodPost, "/repos/:owner/:repo/forks"},
+	{http.MethodGet, "/repos/:owner/:repo/hooks"},
+	{http.MethodGet, "/repos/:owner/:repo/hooks/:id"},
+	{http.MethodPost, "/repos/:owner/:repo/hooks"},
+	{http.MethodPost, "/repos/:owner/:repo/hooks/:id/tests"},
+	{http.MethodDelete, "/repos/:owner/:repo/hooks/:id"},
+	{http.MethodPost, "/repos/:owner/:repo/merges"},
+	{http.MethodGet, "/repos/:owner/:repo/releases"},
+	{http.MethodGet, "/repos/:owner/:repo/releases/:id"},
+	{http.MethodPost, "/repos/:owner/:repo/releases"},
+	{http.MethodDelete, "/repos/:owner/:repo/releases/:id"},
+	{http.MethodGet, "/repos/:owner/:repo/releases/:id/assets"},
+	{http.MethodGet, "/repos/:owner/:repo/stats/contributors"},
+	{http.MethodGet, "/repos/:owner/:repo/stats/commit_activity"},
+	{http.MethodGet, "/repos/:owner/:repo/stats/code_frequency"},
+	{http.MethodGet, "/repos/:owner/:repo/stats/participation"},
+	{http.MethodGet, "/repos/:owner/:repo/stats/punch_card"},
+	{http.MethodGet, "/repos/:owner/:repo/statuses/:ref"},
+	{http.MethodPost, "/repos/:owner/:repo/statuses/:ref"},
+
+	// Search
+	{http.MethodGet, "/search/repositories"},
+	{http.MethodGet, "/search/code"},
+	{http.MethodGet, "/search/issues"},
+	{http.MethodGet, "/search/users"},
+	{http.MethodGet, "/legacy/issues/search/:owner/:repository/:state/:keyword"},
+	{http.MethodGet, "/legacy/repos/search/:keyword"},
+	{http.MethodGet, "/legacy/user/search/:keyword"},
+	{http.MethodGet, "/legacy/user/email/:email"},
+
+	// Users
+	{http.MethodGet, "/users/:user"},
+	{http.MethodGet, "/user"},
+	{http.MethodGet, "/users"},
+	{http.MethodGet, "/user/emails"},
+	{http.MethodPost, "/user/emails"},
+	{http.MethodDelete, "/user/emails"},
+	{http.MethodGet, "/users/:user/followers"},
+	{http.MethodGet, "/user/followers"},
+	{http.MethodGet, "/users/:user/following"},
+	{http.MethodGet, "/user/following"},
+	{http.MethodGet, "/user/following/:user"},
+	{http.MethodGet, "/users/:user/following/:target_user"},
+	{http.MethodPut, "/user/following/:user"},
+	{http.MethodDelete, "/user/following/:user"},
+	{http.MethodGet, "/users/:user/keys"},
+	{http.MethodGet, "/user/keys"},
+	{http.MethodGet, "/user/keys/:id"},
+	{http.MethodPost, "/user/keys"},
+	{http.MethodDelete, "/user/keys/:id"},
+}
+
+var parseAPIRoutes = []benchRoute{
+	// Objects
+	{http.MethodPost, "/1/classes/:className"},
+	{http.MethodGet, "/1/classes/:className/:objectId"},
+	{http.MethodPut, "/1/classes/:className/:objectId"},
+	{http.MethodGet, "/1/classes/:className"},
+	{http.MethodDelete, "/1/classes/:className/:objectId"},
+
+	// Users
+	{http.MethodPost, "/1/users"},
+	{http.MethodGet, "/1/login"},
+	{http.MethodGet, "/1/users/:objectId"},
+	{http.MethodPut, "/1/users/:objectId"},
+	{http.MethodGet, "/1/users"},
+	{http.MethodDelete, "/1/users/:objectId"},
+	{http.MethodPost, "/1/requestPasswordReset"},
+
+	// Roles
+	{http.MethodPost, "/1/roles"},
+	{http.MethodGet, "/1/roles/:objectId"},
+	{http.MethodPut, "/1/roles/:objectId"},
+	{http.MethodGet, "/1/roles"},
+	{http.MethodDelete, "/1/roles/:objectId"},
+
+	// Files
+	{http.MethodPost, "/1/files/:fileName"},
+
+	// Analytics
+	{http.MethodPost, "/1/events/:eventName"},
+
+	// Push Notifications
+	{http.MethodPost, "/1/push"},
+
+	// Installations
+	{http.MethodPost, "/1/installations"},
+	{http.MethodGet, "/1/installations/:objectId"},
+	{http.MethodPut, "/1/installations/:objectId"},
+	{http.MethodGet, "/1/installations"},
+	{http.MethodDelete, "/1/installations/:objectId"},
+
+	// Cloud Functions
+	{http.MethodPost, "/1/functions"},
+}
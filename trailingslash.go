@@ -0,0 +1,88 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 末尾の"/"だけが違うパスへのリクエストの扱い
+type TrailingSlashPolicy uint8
+
+const (
+	// 登録したパスにだけ一致する
+	TrailingSlashStrict TrailingSlashPolicy = iota
+	// 末尾の"/"を付け外ししたパスへのリクエストを、登録したパスへリダイレクトする
+	TrailingSlashRedirect
+	// 末尾の"/"があってもなくても、リダイレクトせずに同じハンドルで処理する
+	TrailingSlashAccept
+)
+
+// ルートごとに末尾の"/"の扱いを決める
+// 指定しない場合はTrailingSlashStrictになる
+func TrailingSlash(policy TrailingSlashPolicy) RouteOption {
+	if policy > TrailingSlashAccept {
+		panic("invalid trailing slash policy")
+	}
+	return func(c *routeConfig) {
+		c.trailingSlash = policy
+	}
+}
+
+// 末尾の"/"を付け外ししたパスにも候補を登録する
+// そのパスにすでにハンドルがあるメソッドには登録せず、明示的に登録したルートを優先する
+// methodsがnilの場合はAnyとして登録する
+func (r *Router) addTrailingSlashRoute(methods []string, path string, names []string, c candidate, policy TrailingSlashPolicy) {
+	if policy == TrailingSlashStrict {
+		return
+	}
+	alternate, ok := toggleTrailingSlash(path)
+	if !ok {
+		return
+	}
+	if policy == TrailingSlashRedirect {
+		c.handle = redirectTrailingSlash
+	}
+
+	table := r.addRoute(alternate)
+	table.setParamNames(names)
+	if methods == nil {
+		if len(table.anyCandidates) == 0 {
+			table.addAny(c)
+		}
+	} else {
+		for _, method := range methods {
+			if table.candidates(method) == nil {
+				table.add(method, c)
+			}
+		}
+	}
+	r.updateMaxParams(table)
+}
+
+// catchAllは末尾の"/"も値に含めるので、付け外ししたパスを作らない
+func toggleTrailingSlash(path string) (string, bool) {
+	if path == "/" || strings.Contains(path, "/*") {
+		return "", false
+	}
+	if path[len(path)-1] == '/' {
+		return path[:len(path)-1], true
+	}
+	return path + "/", true
+}
+
+func redirectTrailingSlash(w http.ResponseWriter, req *http.Request, _ Params) {
+	code := http.StatusMovedPermanently
+	if req.Method != http.MethodGet {
+		code = http.StatusPermanentRedirect
+	}
+	u := *req.URL
+	u.Path, _ = toggleTrailingSlash(u.Path)
+	if u.RawPath != "" {
+		u.RawPath, _ = toggleTrailingSlash(u.RawPath)
+	}
+	http.Redirect(w, req, u.String(), code)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 func min(a, b int) int {
@@ -49,11 +50,12 @@ func findWildcard(path string) (wildcard string, i int, valid bool) {
 
 type Handle func(http.ResponseWriter, *http.Request, Params)
 
-type Param struct {
-	Key   string
-	Value string
+// パラメータの名前はルートの登録時に葉へ記録しておき、探索では値だけを集める
+// namesはルートごとに1つを共有するので書き換えてはいけない
+type Params struct {
+	names  []string
+	values []string
 }
-type Params []Param
 
 type nodeType uint8
 
@@ -69,7 +71,8 @@ type node struct {
 	children         []*node
 	indices          string
 	nType            nodeType
-	handle           Handle
+	table            *methodTable
+	priority         uint32
 	hasParamChild    bool
 	hasCatchAllChild bool
 	hasSlashChild    bool
@@ -107,7 +110,14 @@ func extractCatchAll(path string) (string, int, error) {
 	return path, len(path), nil
 }
 
-func (n *node) addRoute(path string, handle Handle) {
+func (n *node) addRoute(path string) *methodTable {
+	table := n.insertRoute(path)
+	table.setParamNames(paramNames(path))
+	return table
+}
+
+func (n *node) insertRoute(path string) *methodTable {
+	n.priority++
 walk:
 	if n.children == nil {
 		n.children = make([]*node, 0)
@@ -117,27 +127,7 @@ walk:
 
 	if n.nType == static {
 		if i < len(n.path) {
-			prefix := n.path[:i]
-			suffix := n.path[i:]
-
-			child := &node{
-				path:             suffix,
-				nType:            static,
-				children:         n.children,
-				indices:          n.indices,
-				handle:           n.handle,
-				hasParamChild:    n.hasParamChild,
-				hasCatchAllChild: n.hasCatchAllChild,
-				hasSlashChild:    n.hasSlashChild,
-			}
-
-			n.children = []*node{child}
-			n.indices = string(suffix[0])
-			n.path = prefix
-			n.handle = nil
-			n.hasParamChild = false
-			n.hasCatchAllChild = false
-			n.hasSlashChild = suffix[0] == '/'
+			n.split(i)
 		}
 	}
 
@@ -153,9 +143,9 @@ walk:
 			n.checkConflict_param(paramName)
 
 			if len(n.children) == 0 {
-				n.insertChild(path, handle)
+				return n.insertChild(path)
 			} else {
-				n = n.children[0]
+				n = n.children[n.incrementChildPrio(0)]
 				goto walk
 			}
 		case '/':
@@ -164,12 +154,11 @@ walk:
 				next := path[0]
 				for i, c := range []byte(n.indices) {
 					if c == next {
-						n = n.children[i]
+						n = n.children[n.incrementChildPrio(i)]
 						goto walk
 					}
 				}
-				n.insertChild(path, handle)
-				return
+				return n.insertChild(path)
 			}
 			catchAllName, _, err := extractCatchAll(path)
 			if err != nil {
@@ -178,31 +167,98 @@ walk:
 			n.checkConflict_catchAll(catchAllName)
 			for i, c := range []byte(n.indices) {
 				if c == '*' && n.children[i].path == catchAllName {
-					n = n.children[i]
+					n = n.children[n.incrementChildPrio(i)]
 					goto walk
 				}
 			}
-			n.insertChild(path, handle)
+			return n.insertChild(path)
 		case '*':
-			panic("catchAll pattern must be after slash")
+			// "/app/"のように末尾のスラッシュまで静的ノードに含まれている場合は、
+			// スラッシュを子に切り出してから"/*name"として挿入し直す
+			if n.nType != static || len(n.path) == 0 || n.path[len(n.path)-1] != '/' {
+				panic("catchAll pattern must be after slash")
+			}
+			n.split(len(n.path) - 1)
+			path = n.path + "/" + path
+			goto walk
 		default:
 			n.checkConflict_static(path)
 			next := path[0]
 			for i, c := range []byte(n.indices) {
 				if c == next {
-					n = n.children[i]
+					n = n.children[n.incrementChildPrio(i)]
 					goto walk
 				}
 			}
-			n.insertChild(path, handle)
+			return n.insertChild(path)
 		}
-	} else {
-		n.handle = handle
 	}
+	if n.table == nil {
+		n.table = new(methodTable)
+	}
+	return n.table
 }
 
-func (n *node) insertChild(path string, handle Handle) {
-	child := &node{}
+func (n *node) split(i int) {
+	prefix := n.path[:i]
+	suffix := n.path[i:]
+
+	child := &node{
+		path:             suffix,
+		nType:            static,
+		children:         n.children,
+		indices:          n.indices,
+		table:            n.table,
+		hasParamChild:    n.hasParamChild,
+		hasCatchAllChild: n.hasCatchAllChild,
+		hasSlashChild:    n.hasSlashChild,
+		priority:         n.priority - 1,
+	}
+
+	n.children = []*node{child}
+	n.indices = string(suffix[0])
+	n.path = prefix
+	n.table = nil
+	n.hasParamChild = false
+	n.hasCatchAllChild = false
+	n.hasSlashChild = suffix[0] == '/'
+}
+
+// indicesは数文字しかないので、strings.IndexByteを呼ぶより単純なループの方が速い
+func (n *node) indexOf(c byte) int {
+	for i := 0; i < len(n.indices); i++ {
+		if n.indices[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// 子のpriority(配下に登録されたルートの数)を増やし、
+// priorityの大きい子ほど前に来るようにchildrenとindicesを並べ替える
+func (n *node) incrementChildPrio(pos int) int {
+	cs := n.children
+	cs[pos].priority++
+	prio := cs[pos].priority
+
+	newPos := pos
+	for ; newPos > 0 && cs[newPos-1].priority < prio; newPos-- {
+		cs[newPos-1], cs[newPos] = cs[newPos], cs[newPos-1]
+	}
+
+	if newPos != pos {
+		n.indices = n.indices[:newPos] +
+			n.indices[pos:pos+1] +
+			n.indices[newPos:pos] +
+			n.indices[pos+1:]
+	}
+
+	return newPos
+}
+
+func (n *node) insertChild(path string) *methodTable {
+	table := new(methodTable)
+	child := &node{priority: 1}
 	n.children = append(n.children, child)
 	parent := n
 	n = child
@@ -221,8 +277,9 @@ func (n *node) insertChild(path string, handle Handle) {
 				parent.indices += string(path[0])
 				path = path[i:]
 				child := &node{
-					nType: param,
-					path:  wildcard,
+					nType:    param,
+					path:     wildcard,
+					priority: 1,
 				}
 				n.children = []*node{child}
 				n.indices = ":"
@@ -238,15 +295,15 @@ func (n *node) insertChild(path string, handle Handle) {
 
 			if len(wildcard) < len(path) {
 				path = path[len(wildcard):]
-				child := &node{}
+				child := &node{priority: 1}
 				n.children = []*node{child}
 				parent = n
 				n = child
 				continue
 			} else {
-				n.handle = handle
+				n.table = table
 			}
-			return
+			return table
 		} else if wildcard[0:2] == "/*" {
 			if i+len(wildcard) != len(path) {
 				panic("catchAll must be the last pattern")
@@ -255,9 +312,10 @@ func (n *node) insertChild(path string, handle Handle) {
 				n.path = path[:i]
 				parent.indices += string(path[0])
 				child := &node{
-					nType:  catchAll,
-					path:   wildcard,
-					handle: handle,
+					nType:    catchAll,
+					path:     wildcard,
+					table:    table,
+					priority: 1,
 				}
 				n.children = []*node{child}
 				n.indices = "*"
@@ -265,11 +323,11 @@ func (n *node) insertChild(path string, handle Handle) {
 			} else {
 				n.path = wildcard
 				n.nType = catchAll
-				n.handle = handle
+				n.table = table
 				parent.hasCatchAllChild = true
 				parent.indices += "*"
 			}
-			return
+			return table
 		}
 	}
 	if path[0] == '/' {
@@ -277,7 +335,8 @@ func (n *node) insertChild(path string, handle Handle) {
 	}
 	parent.indices += string(path[0])
 	n.path = path
-	n.handle = handle
+	n.table = table
+	return table
 }
 
 type conflictPanic struct {
@@ -287,22 +346,12 @@ type conflictPanic struct {
 }
 
 func (n *node) checkConflict_static(str string) {
-	if n.nType == static {
-		if !(n.hasParamChild || n.hasCatchAllChild) {
-			return
-		}
-		if n.hasCatchAllChild && str[0] != '/' {
-			return
-		}
+	if n.nType == static && !n.hasParamChild {
+		return
 	}
 
 	if n.nType == param && str[0] == '/' {
-		if len(n.children) == 0 {
-			return
-		}
-		if n.children[0].nType == static {
-			return
-		}
+		return
 	}
 
 	panic(conflictPanic{
@@ -313,23 +362,14 @@ func (n *node) checkConflict_static(str string) {
 }
 
 func (n *node) checkConflict_catchAll(catchAllName string) {
-	if n.nType == static {
-		if len(n.children) == 0 {
-			return
-		}
-		if len(n.children) == 1 && n.children[0].nType == catchAll && n.children[0].path == catchAllName {
-			return
-		}
-		if !n.hasSlashChild && !n.hasParamChild && !n.hasCatchAllChild {
+	if !n.hasParamChild {
+		if !n.hasCatchAllChild {
 			return
 		}
-	}
-	if n.nType == param {
-		if len(n.children) == 0 {
-			return
-		}
-		if len(n.children) == 1 && n.children[0].nType == catchAll && n.children[0].path == catchAllName {
-			return
+		for _, child := range n.children {
+			if child.nType == catchAll && child.path == catchAllName {
+				return
+			}
 		}
 	}
 	panic(conflictPanic{
@@ -353,84 +393,135 @@ func (n *node) checkConflict_param(paramName string) {
 	})
 }
 
-type paramsProvider interface {
-	add(Param)
-	getParams() *Params
+// 探索中に見つかったパラメータを集める
+// インターフェースを通さず、呼び出し側のスタックに置けるように具体的な型にしている
+// nilの場合はパラメータを集めない
+type paramsCollector struct {
+	ps   *Params
+	pool *sync.Pool
+	size int
 }
 
-type funcParamsProvider struct {
-	ps          *Params
-	provideFunc func() *Params
+func (c *paramsCollector) add(value string) {
+	if c == nil {
+		return
+	}
+	if c.ps == nil {
+		c.ps = paramsFromPool(c.pool, c.size)
+	}
+	c.ps.values = append(c.ps.values, value)
 }
 
-func (f *funcParamsProvider) add(p Param) {
-	if f.ps == nil {
-		f.ps = f.provideFunc()
+func (c *paramsCollector) len() int {
+	if c == nil || c.ps == nil {
+		return 0
 	}
-	i := len(*(f.ps))
-	*f.ps = (*(f.ps))[:i+1]
-	(*(f.ps))[i] = p
+	return len(c.ps.values)
 }
 
-func (f *funcParamsProvider) getParams() *Params {
-	return f.ps
+func (c *paramsCollector) truncate(i int) {
+	if c != nil && c.ps != nil {
+		c.ps.values = c.ps.values[:i]
+	}
 }
 
-func (n *node) retrieve(path string, params func() *Params) (handle Handle, ps *Params) {
-	return n._retrieve(path, &funcParamsProvider{
-		provideFunc: params,
-	})
+func (c *paramsCollector) getParams() *Params {
+	if c == nil {
+		return nil
+	}
+	return c.ps
 }
 
-type nilParamsProvider struct{}
-
-func (n *nilParamsProvider) add(p Param)        {}
-func (n *nilParamsProvider) getParams() *Params { return nil }
+func (n *node) retrieve(path, method string, handleHEAD bool, pool *sync.Pool, size int) (table *methodTable, ps *Params) {
+	c := paramsCollector{pool: pool, size: size}
+	return n._retrieve(path, method, handleHEAD, &c)
+}
 
-func (n *node) retrieve_noparam(path string) Handle {
-	handle, _ := n._retrieve(path, &nilParamsProvider{})
-	return handle
+func (n *node) retrieve_noparam(path, method string, handleHEAD bool) *methodTable {
+	table, _ := n._retrieve(path, method, handleHEAD, nil)
+	return table
 }
 
-func (n *node) _retrieve(path string, provider paramsProvider) (handle Handle, ps *Params) {
-walk:
-	if len(path) == 0 {
-		return n.handle, provider.getParams()
-	}
-	for _, child := range n.children {
-		switch child.nType {
-		case static:
-			if child.path == path[:len(child.path)] {
+// pathを_retrieveと同じ順にたどり、行き止まりやメソッドがない場合に戻る先のcatchAllを返す
+// Allowヘッダーに、catchAllに戻って処理できるメソッドも含めるために使う
+func (n *node) catchAllFallback(path string) *methodTable {
+	var fallback *node
+	for len(path) > 0 {
+		if n.hasCatchAllChild && path[0] == '/' {
+			fallback = n.children[n.indexOf('*')]
+		}
+		if i := n.indexOf(path[0]); i >= 0 {
+			child := n.children[i]
+			if child.nType == static && len(child.path) <= len(path) && child.path == path[:len(child.path)] {
 				n = child
 				path = path[len(child.path):]
-				goto walk
-			}
-		case param:
-			if path[0] == '/' {
-				return nil, nil
+				continue
 			}
-			end := 1
-			for end < len(path) && path[end] != '/' {
-				end++
+		}
+		if !n.hasParamChild || path[0] == '/' {
+			break
+		}
+		end := 1
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+		n = n.children[0]
+		path = path[end:]
+	}
+	if fallback == nil {
+		return nil
+	}
+	return fallback.table
+}
+
+// 静的な子を優先して探索し、行き止まりになった場合は最後に通過したcatchAllに戻る
+// methodを指定すると、見つかったパスにそのメソッドがなくcatchAllにはある場合もcatchAllに戻る
+func (n *node) _retrieve(path, method string, handleHEAD bool, params *paramsCollector) (table *methodTable, ps *Params) {
+	var fallback *node
+	var fallbackPath string
+	var fallbackParams int
+walk:
+	if len(path) == 0 {
+		if n.table != nil {
+			if method == "" || fallback == nil || n.table.has(method, handleHEAD) || !fallback.table.has(method, handleHEAD) {
+				return n.table, params.getParams()
 			}
-			provider.add(Param{
-				Key:   child.path[1:],
-				Value: path[:end],
-			})
+		}
+		goto fallback
+	}
+	if n.hasCatchAllChild && path[0] == '/' {
+		fallback = n.children[n.indexOf('*')]
+		fallbackPath = path
+		fallbackParams = params.len()
+	}
+	if i := n.indexOf(path[0]); i >= 0 {
+		child := n.children[i]
+		if child.nType == static && len(child.path) <= len(path) && child.path == path[:len(child.path)] {
 			n = child
-			path = path[end:]
+			path = path[len(child.path):]
 			goto walk
-		case catchAll:
-			if path[0] == '/' {
-				provider.add(Param{
-					Key:   child.path[2:],
-					Value: path,
-				})
-				n = child
-				path = path[len(path):]
-				goto walk
-			}
 		}
 	}
-	return nil, nil
+	if n.hasParamChild {
+		if path[0] == '/' {
+			goto fallback
+		}
+		// パラメータの子は兄弟を持たない
+		child := n.children[0]
+		end := 1
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+		params.add(path[:end])
+		n = child
+		path = path[end:]
+		goto walk
+	}
+fallback:
+	if fallback == nil {
+		return nil, nil
+	}
+	params.truncate(fallbackParams)
+	params.add(fallbackPath)
+	return fallback.table, params.getParams()
 }
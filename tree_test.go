@@ -0,0 +1,340 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func catchPanic(testFunc func()) (recv interface{}) {
+	defer func() {
+		recv = recover()
+	}()
+
+	testFunc()
+	return
+}
+
+var fakeHandlerValue string
+
+func fakeHandler(val string) Handle {
+	return func(http.ResponseWriter, *http.Request, Params) {
+		fakeHandlerValue = val
+	}
+}
+
+var testParamsPool = sync.Pool{
+	New: func() interface{} {
+		return &Params{values: make([]string, 0, 10)}
+	},
+}
+
+func newTestCollector() *paramsCollector {
+	return &paramsCollector{pool: &testParamsPool}
+}
+
+// 木のテストでは条件のないハンドルを1つだけ登録して取り出す
+func (t *methodTable) set(method string, handle Handle) {
+	t.add(method, candidate{handle: handle})
+}
+
+func (t *methodTable) get(method string) Handle {
+	if candidates := t.candidates(method); len(candidates) > 0 {
+		return candidates[0].handle
+	}
+	return nil
+}
+
+type retrieveTest struct {
+	path          string
+	expectedValue string
+	parameters    map[string]string
+}
+
+func checkRetrieve(t *testing.T, n *node, tests []retrieveTest) {
+	for _, test := range tests {
+		table, ps := n._retrieve(test.path, "", false, newTestCollector())
+		var handler Handle
+		if table != nil {
+			handler = table.get(http.MethodGet)
+		}
+		if handler == nil {
+			if test.expectedValue != "" {
+				t.Errorf("retrieve(%s) = nil, want %s", test.path, test.expectedValue)
+			}
+			continue
+		}
+		if test.expectedValue == "" {
+			t.Errorf("retrieve(%s) returned a handler, want nil", test.path)
+			continue
+		}
+		handler(nil, nil, Params{})
+		if fakeHandlerValue != test.expectedValue {
+			t.Errorf("retrieve(%s) handler set fakeHandlerValue = %s, want %s", test.path, fakeHandlerValue, test.expectedValue)
+		}
+		if ps == nil && len(test.parameters) == 0 {
+			continue
+		}
+		if ps == nil || ps.Len() != len(test.parameters) {
+			t.Errorf("retrieve(%s) returned %v parameters; want %v", test.path, ps, test.parameters)
+			continue
+		}
+		ps.names = table.names
+		for name, value := range test.parameters {
+			if ps.ByName(name) != value {
+				t.Errorf("retrieve(%s) returned parameter %s with value %s; want %s", test.path, name, ps.ByName(name), value)
+			}
+		}
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	n := &node{}
+	n.addRoute("/a").set(http.MethodGet, fakeHandler("dummy1"))
+	n.addRoute("/a/:path").set(http.MethodGet, fakeHandler("dummy2"))
+	n.addRoute("/a/:path/*everything").set(http.MethodGet, fakeHandler("dummy3"))
+	n.addRoute("/x").set(http.MethodGet, fakeHandler("dummy4"))
+	n.addRoute("/xy").set(http.MethodGet, fakeHandler("dummy5"))
+	n.addRoute("/xz").set(http.MethodGet, fakeHandler("dummy6"))
+	n.addRoute("/xz/*file").set(http.MethodGet, fakeHandler("dummy7"))
+	n.addRoute("/xzz").set(http.MethodGet, fakeHandler("dummy8"))
+	n.addRoute("/xy:id").set(http.MethodGet, fakeHandler("dummy9"))
+	n.addRoute("/xy:id/n").set(http.MethodGet, fakeHandler("dummy10"))
+
+	checkRetrieve(t, n, []retrieveTest{
+		{"/a", "dummy1", nil},
+		{"/a/012", "dummy2", map[string]string{
+			"path": "012",
+		}},
+		{"/a/012/yeah", "dummy3", map[string]string{
+			"path":       "012",
+			"everything": "/yeah",
+		}},
+		{"/a/b/yeah:good", "dummy3", map[string]string{
+			"path":       "b",
+			"everything": "/yeah:good",
+		}},
+		{"/x", "dummy4", nil},
+		{"/xy", "dummy5", nil},
+		{"/xz", "dummy6", nil},
+		{"/xz/", "dummy7", map[string]string{
+			"file": "/",
+		}},
+		{"/xz/hoge/fuga", "dummy7", map[string]string{
+			"file": "/hoge/fuga",
+		}},
+		{"/xzz", "dummy8", nil},
+		{"/xyz", "dummy9", map[string]string{
+			"id": "z",
+		}},
+		{"/xyzzz/n", "dummy10", map[string]string{
+			"id": "zzz",
+		}},
+		{"/b", "", nil},
+	})
+}
+
+func TestRetrieveCatchAllWithStaticSiblings(t *testing.T) {
+	n := &node{}
+	n.addRoute("/").set(http.MethodGet, fakeHandler("index"))
+	n.addRoute("/api/users").set(http.MethodGet, fakeHandler("users"))
+	n.addRoute("/*filepath").set(http.MethodGet, fakeHandler("spa"))
+	n.addRoute("/api/users/:id").set(http.MethodGet, fakeHandler("user"))
+	n.addRoute("/app/").set(http.MethodGet, fakeHandler("app"))
+	n.addRoute("/app/*rest").set(http.MethodGet, fakeHandler("app-rest"))
+	n.addRoute("/app/settings").set(http.MethodGet, fakeHandler("settings"))
+	n.addRoute("/u/:id/*rest").set(http.MethodGet, fakeHandler("u-rest"))
+	n.addRoute("/u/:id/profile").set(http.MethodGet, fakeHandler("u-profile"))
+
+	checkRetrieve(t, n, []retrieveTest{
+		{"/", "index", nil},
+		{"/api/users", "users", nil},
+		{"/api/users/42", "user", map[string]string{
+			"id": "42",
+		}},
+		{"/api", "spa", map[string]string{
+			"filepath": "/api",
+		}},
+		{"/api/users/", "spa", map[string]string{
+			"filepath": "/api/users/",
+		}},
+		{"/api/users/42/posts", "spa", map[string]string{
+			"filepath": "/api/users/42/posts",
+		}},
+		{"/about", "spa", map[string]string{
+			"filepath": "/about",
+		}},
+		{"/app/", "app", nil},
+		{"/app/settings", "settings", nil},
+		{"/app/set", "app-rest", map[string]string{
+			"rest": "/set",
+		}},
+		{"/app/settings/x", "app-rest", map[string]string{
+			"rest": "/settings/x",
+		}},
+		{"/u/1/profile", "u-profile", map[string]string{
+			"id": "1",
+		}},
+		{"/u/1/posts", "u-rest", map[string]string{
+			"id":   "1",
+			"rest": "/posts",
+		}},
+	})
+}
+
+func TestAddRouteConflict(t *testing.T) {
+	tests := []struct {
+		routes   []string
+		conflict bool
+	}{
+		{[]string{"/files/*filepath", "/files/index"}, false},
+		{[]string{"/files/index", "/files/*filepath"}, false},
+		{[]string{"/files/*filepath", "/files/*name"}, true},
+		{[]string{"/files/*filepath", "/files/:name"}, false},
+		{[]string{"/files/:name", "/files/*filepath"}, false},
+		{[]string{"/files/:name", "/files/:id"}, true},
+		{[]string{"/files/:name", "/files/index"}, true},
+		{[]string{"/files:name", "/files/*filepath"}, true},
+	}
+
+	for _, test := range tests {
+		n := &node{}
+		var recv interface{}
+		for _, route := range test.routes {
+			recv = catchPanic(func() {
+				n.addRoute(route).set(http.MethodGet, fakeHandler(route))
+			})
+		}
+		if (recv != nil) != test.conflict {
+			t.Errorf("addRoute(%v) panic = %v, want conflict %v", test.routes, recv, test.conflict)
+		}
+	}
+}
+
+func checkPriorities(t *testing.T, n *node) uint32 {
+	var prio uint32
+	for i, child := range n.children {
+		prio += checkPriorities(t, child)
+		if i > 0 && n.children[i-1].priority < child.priority {
+			t.Errorf("children of node %q are not sorted by priority", n.path)
+		}
+		var index byte
+		switch child.nType {
+		case param:
+			index = ':'
+		case catchAll:
+			index = '*'
+		default:
+			index = child.path[0]
+		}
+		if n.indices[i] != index {
+			t.Errorf("indices of node %q = %q, child %d is %q", n.path, n.indices, i, child.path)
+		}
+	}
+	if n.table != nil {
+		prio++
+	}
+	if n.priority != prio {
+		t.Errorf("priority of node %q = %d, want %d", n.path, n.priority, prio)
+	}
+	return prio
+}
+
+func TestPriority(t *testing.T) {
+	n := &node{}
+	routes := []string{
+		"/",
+		"/cmd/:tool/:sub",
+		"/cmd/:tool/",
+		"/src/*filepath",
+		"/search/",
+		"/search/:query",
+		"/user_:name",
+		"/user_:name/about",
+		"/files/:dir/*filepath",
+		"/doc/",
+		"/doc/go_faq.html",
+		"/doc/go1.html",
+		"/info/:user/public",
+		"/info/:user/project/:project",
+		"/api/v1/users",
+		"/api/v1/users/:id",
+		"/api/v1/users/:id/posts",
+		"/api/v1/users/:id/posts/:post",
+		"/api/v1/teams",
+		"/api/v2/users",
+	}
+	for _, route := range routes {
+		n.addRoute(route).set(http.MethodGet, fakeHandler(route))
+	}
+
+	if prio := checkPriorities(t, n); prio != uint32(len(routes)) {
+		t.Errorf("root priority = %d, want %d", prio, len(routes))
+	}
+	if first := n.children[0]; first.path != "/" || first.children[0].path != "api/v" {
+		t.Errorf("busiest subtree /api/v is not checked first: %q", n.children[0].indices)
+	}
+
+	for _, route := range routes {
+		path := requestPath(route)
+		table, _ := n._retrieve(path, "", false, newTestCollector())
+		if table == nil {
+			t.Errorf("retrieve(%s) = nil after reordering", path)
+			continue
+		}
+		table.get(http.MethodGet)(nil, nil, Params{})
+		if fakeHandlerValue != route {
+			t.Errorf("retrieve(%s) = %s, want %s", path, fakeHandlerValue, route)
+		}
+	}
+}
+
+func TestFreezeTree(t *testing.T) {
+	spaRoutes := []benchRoute{
+		{http.MethodGet, "/"},
+		{http.MethodPost, "/api/users"},
+		{http.MethodGet, "/*filepath"},
+		{http.MethodGet, "/api/users/:id"},
+		{http.MethodGet, "/app/*rest"},
+		{http.MethodGet, "/app/settings"},
+		{http.MethodGet, "/u/:id/*rest"},
+		{http.MethodGet, "/u/:id/profile"},
+	}
+	sets := [][]benchRoute{staticRoutes, githubAPIRoutes, parseAPIRoutes, spaRoutes}
+	extraPaths := []string{"", "/", "/missing", "/api/users", "/api/users/", "/app/set", "/u/1/posts", "/repos/a"}
+
+	for _, routes := range sets {
+		n := loadRouter(routes).tree
+		frozen := freezeTree(n)
+
+		paths := slices.Clone(extraPaths)
+		for _, route := range routes {
+			paths = append(paths, requestPath(route.path))
+		}
+		for _, path := range paths {
+			for _, method := range []string{"", http.MethodGet, http.MethodPost} {
+				table, ps := n._retrieve(path, method, false, newTestCollector())
+				frozenTable, frozenPs := frozen._retrieve(path, method, false, newTestCollector())
+				if table != frozenTable {
+					t.Errorf("frozen retrieve(%s, %s) returned a different table", path, method)
+					continue
+				}
+				var params, frozenParams []string
+				if ps != nil {
+					params = ps.values
+				}
+				if frozenPs != nil {
+					frozenParams = frozenPs.values
+				}
+				if !slices.Equal(params, frozenParams) {
+					t.Errorf("frozen retrieve(%s, %s) params = %v, want %v", path, method, frozenParams, params)
+				}
+			}
+		}
+	}
+}
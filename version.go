@@ -0,0 +1,142 @@
+// Copyright 2024 進捗ゼミ. All rights reserved.
+// Based on the path package, Copyright 2009 The Go Authors.
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+package zerorouter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ルートをAPIのバージョンvに登録する
+// リクエストのバージョンに対しては、それ以下で最も新しいバージョンのハンドルが選ばれる
+// バージョンを指定していないハンドルは、どのバージョンよりも古いものとして扱う
+func APIVersion(v int) RouteOption {
+	if v < 1 {
+		panic("API version must be positive")
+	}
+	return func(c *routeConfig) {
+		c.version = v
+	}
+}
+
+// リクエストのバージョンの決め方
+// 複数を設定した場合は、パスの先頭、ヘッダー、Acceptの順に調べる
+// どれからも決まらない場合は最新のバージョンを使う
+type Versioning struct {
+	// "/v2/users"のようにパスの先頭に付いたバージョンを取り除いてからルートを探す
+	PathPrefix bool
+	// "2"や"v2"のようにバージョンを書くヘッダーの名前
+	Header string
+	// "application/vnd.example"とすると、Acceptの"application/vnd.example.v2+json"からバージョンを読む
+	MediaType string
+}
+
+type deprecation struct {
+	deprecation []string
+	sunset      []string
+}
+
+// バージョンvのハンドルが返すレスポンスに、RFC 9745の形式で非推奨になった日時をDeprecationヘッダーで付ける
+// sunsetがゼロでなければ、廃止の予定日をSunsetヘッダーで知らせる
+func (r *Router) DeprecateVersion(v int, deprecated, sunset time.Time) {
+	if deprecated.IsZero() {
+		panic("deprecation time must not be zero")
+	}
+	if r.deprecations == nil {
+		r.deprecations = make(map[int]deprecation)
+	}
+	d := deprecation{deprecation: []string{"@" + strconv.FormatInt(deprecated.Unix(), 10)}}
+	if !sunset.IsZero() {
+		d.sunset = []string{sunset.UTC().Format(http.TimeFormat)}
+	}
+	r.deprecations[v] = d
+}
+
+// パスからバージョンを読んだ場合は、それを取り除いたパスも返す
+func (r *Router) requestedVersion(req *http.Request, urlPath string) (int, string) {
+	if r.Versioning.PathPrefix && len(urlPath) > 2 && urlPath[1] == 'v' {
+		end := 2
+		for end < len(urlPath) && urlPath[end] != '/' {
+			end++
+		}
+		if v, ok := parseVersion(urlPath[2:end]); ok {
+			if end == len(urlPath) {
+				return v, "/"
+			}
+			return v, urlPath[end:]
+		}
+	}
+	if r.Versioning.Header != "" {
+		if v, ok := parseVersion(strings.TrimPrefix(req.Header.Get(r.Versioning.Header), "v")); ok {
+			return v, urlPath
+		}
+	}
+	if r.Versioning.MediaType != "" {
+		if v, ok := vendorVersion(req.Header.Get("Accept"), r.Versioning.MediaType); ok {
+			return v, urlPath
+		}
+	}
+	return 0, urlPath
+}
+
+func parseVersion(s string) (int, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	v, err := strconv.Atoi(s)
+	return v, err == nil && v > 0
+}
+
+// "application/vnd.example.v2+json"のようなメディアタイプからバージョンを読む
+func vendorVersion(accept, mediaType string) (int, bool) {
+	for accept != "" {
+		var part string
+		part, accept, _ = strings.Cut(accept, ",")
+		part = strings.TrimSpace(part)
+		if len(part) < len(mediaType)+2 || !strings.EqualFold(part[:len(mediaType)], mediaType) || part[len(mediaType):len(mediaType)+2] != ".v" {
+			continue
+		}
+		part = part[len(mediaType)+2:]
+		end := 0
+		for end < len(part) && '0' <= part[end] && part[end] <= '9' {
+			end++
+		}
+		if v, ok := parseVersion(part[:end]); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// 候補の中から、versionに最も近い古いバージョンを選ぶ
+// versionが0の場合は最新のバージョンを選ぶ
+func targetVersion(candidates []candidate, version int) (int, bool) {
+	target := -1
+	for i := range candidates {
+		v := candidates[i].version
+		if (version == 0 || v <= version) && v > target {
+			target = v
+		}
+	}
+	return target, target >= 0
+}
+
+func (r *Router) setVersionHeaders(w http.ResponseWriter, c *candidate) {
+	h := w.Header()
+	if r.Versioning.Header != "" {
+		h.Add("Vary", r.Versioning.Header)
+	}
+	if r.Versioning.MediaType != "" && !c.vary {
+		h.Add("Vary", "Accept")
+	}
+	if d, ok := r.deprecations[c.version]; ok {
+		h["Deprecation"] = d.deprecation
+		if d.sunset != nil {
+			h["Sunset"] = d.sunset
+		}
+	}
+}